@@ -1,6 +1,22 @@
 package main
 
-import "os/exec"
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+var (
+	// Binaries which can be overridden per cluster
+	overridableBinaries = []string{"redis-server", "redis-cli"}
+
+	redisServerVersionRegEx = regexp.MustCompile(`v=(\d+)\.(\d+)`)
+)
+
+func UnknownBinaryError(name string) error {
+	return fmt.Errorf("Unknown binary '%s'. Only redis-server and redis-cli can be overridden", name)
+}
 
 type Binaries struct {
 	binaries map[string]string
@@ -27,6 +43,60 @@ func NewBinaries() (*Binaries, error) {
 	return &Binaries{binaries: binaries}, nil
 }
 
+// Checks that overridden binaries are known and can be executed
+func ValidateBinaries(overrides map[string]string) error {
+	for name, binary := range overrides {
+		if !contains(overridableBinaries, name) {
+			return UnknownBinaryError(name)
+		}
+
+		if _, err := exec.LookPath(binary); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the copy with binaries replaced by the overrides
+func (self *Binaries) With(overrides map[string]string) *Binaries {
+	if len(overrides) < 1 {
+		return self
+	}
+
+	binaries := make(map[string]string, len(self.binaries))
+
+	for name, binary := range self.binaries {
+		binaries[name] = binary
+	}
+
+	for name, binary := range overrides {
+		binaries[name] = binary
+	}
+
+	return &Binaries{binaries: binaries}
+}
+
+// Tells whether redis-server knows ACL rules of Pub/Sub channels. The version is unknown when redis-server can't be run
+func (self *Binaries) AclChannelsSupported() bool {
+	output, err := exec.Command(self.RedisServer(), "--version").Output()
+	return err == nil && aclChannelsSupported(string(output))
+}
+
+// Parses output of redis-server --version. Channel rules appeared in Redis 6.2
+func aclChannelsSupported(versionOutput string) bool {
+	match := redisServerVersionRegEx.FindStringSubmatch(versionOutput)
+
+	if match == nil {
+		return false
+	}
+
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+
+	return major > 6 || major == 6 && minor >= 2
+}
+
 func (self *Binaries) RedisServer() string {
 	return self.binaries["redis-server"]
 }
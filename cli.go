@@ -6,9 +6,15 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-const RcmHome string = ".rcm"
+const (
+	RcmHome    string = ".rcm"
+	RcmHomeEnv string = "RCM_HOME"
+)
 
 func first(args []string) string {
 	if len(args) > 0 {
@@ -24,8 +30,25 @@ func printError(err interface{}) {
 	}
 }
 
-func main() {
+// Returns the directory clusters are kept in. It can be overridden with RCM_HOME environment variable. The path is made
+// absolute since nodes resolve relative paths of their configuration against their own working directory
+func rcmHomeDir() (string, error) {
+	if home := os.Getenv(RcmHomeEnv); len(home) > 0 {
+		return filepath.Abs(home)
+	}
+
 	usr, err := user.Current()
+
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(usr.HomeDir, RcmHome), nil
+}
+
+func main() {
+	homeDir, err := rcmHomeDir()
+
 	if err != nil {
 		printError(err)
 		return
@@ -38,7 +61,7 @@ func main() {
 		return
 	}
 
-	clusterSet, err := NewClusterSet(path.Join(usr.HomeDir, RcmHome), binaries)
+	clusterSet, err := NewClusterSet(homeDir, binaries)
 
 	if err != nil {
 		printError(err)
@@ -64,40 +87,112 @@ func main() {
 			Name:  "create",
 			Usage: "Creates a new cluster",
 			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "YAML spec of the cluster. The cluster is created, started and its slots are distributed",
+				},
+				cli.BoolFlag{
+					Name:  "yes, y",
+					Usage: "do not ask for confirmation",
+				},
 				cli.StringFlag{
 					Name:  "listen, l",
 					Value: "127.0.0.1",
-					Usage: "listen on host for incomming connection (host to bind to)",
+					Usage: "comma separated IPv4/IPv6 addresses or host names to bind to. Host names are resolved to addresses. " +
+						"The first one is used by the cluster bus",
 				},
 				cli.IntFlag{
 					Name:  "nodes, n",
 					Value: 6,
 					Usage: "number of nodes to create",
 				},
-				cli.BoolFlag{
-					Name:  "persistance, s",
-					Usage: "enable persistance",
+				cli.StringFlag{
+					Name:  "persistence, s",
+					Value: PersistenceNone,
+					Usage: "persistence mode (rdb, aof, both or none)",
+				},
+				cli.StringSliceFlag{
+					Name:  "save",
+					Usage: "RDB save point in form '<seconds> <changes>' (can be repeated)",
+				},
+				cli.StringFlag{
+					Name:  "appendfsync",
+					Usage: "AOF fsync policy (always, everysec or no)",
 				},
 				cli.IntFlag{
 					Name:  "start-port, p",
 					Value: 9001,
 					Usage: "port of the first node",
 				},
+				cli.StringSliceFlag{
+					Name:  "conf, c",
+					Usage: "additional redis.conf directive in form key=value (can be repeated). Overrides --conf-file except for repeatable ones like rename-command",
+				},
+				cli.StringFlag{
+					Name:  "conf-file",
+					Usage: "base redis.conf file with additional directives for each node",
+				},
+				cli.StringFlag{
+					Name:  "password, a",
+					Usage: "password of the default user (requirepass and masterauth)",
+				},
+				cli.StringSliceFlag{
+					Name:  "user, u",
+					Usage: "ACL user in form name:password[:rules] (Redis 6+, can be repeated)",
+				},
+				cli.BoolFlag{
+					Name:  "tls",
+					Usage: "enable TLS with locally generated certificates (Redis 6+)",
+				},
+				cli.BoolFlag{
+					Name:  "unixsocket",
+					Usage: "listen on unix socket in addition to TCP port",
+				},
+				cli.IntFlag{
+					Name:  "hosts",
+					Value: 1,
+					Usage: "number of simulated hosts. Nodes are spread across consecutive loopback addresses " +
+						"(on OS X the addresses should be aliased to lo0 first)",
+				},
 			},
 			Action: func(c *cli.Context) {
+				if c.IsSet("file") {
+					err := controller.CreateFromSpec(first(c.Args()), c.String("file"), c.Bool("yes"))
+					printError(err)
+					return
+				}
+
 				err := controller.Create(
 					first(c.Args()),
 					CreateProperties{
 						nodesCount:                c.Int("nodes"),
 						startPort:                 c.Int("start-port"),
 						listenIp:                  c.String("listen"),
-						persistence:               c.Bool("persistance"),
+						persistenceMode:           c.String("persistence"),
+						savePoints:                c.StringSlice("save"),
+						appendFsync:               c.String("appendfsync"),
+						confFile:                  c.String("conf-file"),
+						confDirectives:            c.StringSlice("conf"),
+						password:                  c.String("password"),
+						users:                     c.StringSlice("user"),
+						tls:                       c.Bool("tls"),
+						unixSocket:                c.Bool("unixsocket"),
+						hostsCount:                c.Int("hosts"),
 						performFinalConfiguration: false,
-						sayYes: false,
+						sayYes:                    c.Bool("yes"),
 					})
 				printError(err)
 			},
 		},
+		cli.Command{
+			Name:        "export",
+			Usage:       "Prints the spec the cluster can be recreated from with 'create -f'",
+			Description: "Usage: rcm export <cluster> > cluster-spec.yml",
+			Action: func(c *cli.Context) {
+				err := controller.Export(first(c.Args()))
+				printError(err)
+			},
+		},
 		cli.Command{
 			Name:    "remove",
 			Aliases: []string{"rm"},
@@ -107,6 +202,31 @@ func main() {
 				printError(err)
 			},
 		},
+		cli.Command{
+			Name:        "mv",
+			Aliases:     []string{"rename"},
+			Usage:       "Renames the stopped cluster",
+			Description: "Usage: rcm mv <cluster> <new name>",
+			Action: func(c *cli.Context) {
+				err := controller.Mv(first(c.Args()), c.Args().Get(1))
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:        "clone",
+			Usage:       "Copies the stopped cluster with its topology and data to new ports",
+			Description: "Usage: rcm clone <source> <destination> [--start-port <port>]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "start-port, p",
+					Usage: "first port of the clone. Ports following the highest port of the source are used by default",
+				},
+			},
+			Action: func(c *cli.Context) {
+				err := controller.Clone(first(c.Args()), c.Args().Get(1), c.Int("start-port"))
+				printError(err)
+			},
+		},
 		cli.Command{
 			Name:  "start",
 			Usage: "Starts the cluster",
@@ -123,6 +243,295 @@ func main() {
 				printError(err)
 			},
 		},
+		cli.Command{
+			Name:        "add-node",
+			Usage:       "Adds new nodes to the running cluster",
+			Description: "Usage: rcm add-node <cluster> [--count N] [--replica-of <port>|--master]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "count, n",
+					Value: 1,
+					Usage: "number of nodes to add",
+				},
+				cli.IntFlag{
+					Name:  "replica-of, r",
+					Usage: "port of the master new nodes should replicate",
+				},
+				cli.BoolFlag{
+					Name:  "master, m",
+					Usage: "add nodes as empty masters (default)",
+				},
+			},
+			Action: func(c *cli.Context) {
+				err := controller.AddNode(first(c.Args()), c.Int("count"), c.Int("replica-of"), c.Bool("master"))
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:        "remove-node",
+			Usage:       "Removes the node from the running cluster migrating its slots to other masters",
+			Description: "Usage: rcm remove-node <cluster> <port>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "remove the node even if its slots would be left uncovered",
+				},
+			},
+			Action: func(c *cli.Context) {
+				port, _ := strconv.Atoi(c.Args().Get(1))
+				err := controller.RemoveNode(first(c.Args()), port, c.Bool("force"))
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:        "reshard",
+			Usage:       "Moves slots with their keys between masters",
+			Description: "Usage: rcm reshard <cluster> --from <port> --to <port> (--slots N | --slot-range N-M...)",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "from",
+					Usage: "port of the master to move slots from",
+				},
+				cli.IntFlag{
+					Name:  "to",
+					Usage: "port of the master to move slots to",
+				},
+				cli.IntFlag{
+					Name:  "slots, n",
+					Usage: "number of slots to move",
+				},
+				cli.StringSliceFlag{
+					Name:  "slot-range, s",
+					Usage: "range of slots to move in form N or N-M (can be repeated)",
+				},
+				cli.IntFlag{
+					Name:  "pipeline, p",
+					Value: DefaultMigrationPipeline,
+					Usage: "number of keys moved by single MIGRATE command",
+				},
+				cli.IntFlag{
+					Name:  "timeout, t",
+					Value: DefaultMigrationTimeout,
+					Usage: "timeout of single MIGRATE command in milliseconds",
+				},
+				cli.BoolFlag{
+					Name:  "replace",
+					Usage: "replace keys which already exist on the target",
+				},
+			},
+			Action: func(c *cli.Context) {
+				err := controller.Reshard(
+					first(c.Args()),
+					c.Int("from"),
+					c.Int("to"),
+					c.Int("slots"),
+					c.StringSlice("slot-range"),
+					MigrationOptions{
+						Pipeline: c.Int("pipeline"),
+						Timeout:  c.Int("timeout"),
+						Replace:  c.Bool("replace"),
+					})
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:        "rebalance",
+			Usage:       "Evens out slots across masters moving them with their keys",
+			Description: "Usage: rcm rebalance <cluster> [--weight <port>=<weight>...] [--threshold <percents>]",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "weight, w",
+					Usage: "relative share of slots of the master in form <port>=<weight>. Default weight is 1 (can be repeated)",
+				},
+				cli.Float64Flag{
+					Name:  "threshold",
+					Value: DefaultRebalanceThreshold,
+					Usage: "percents a master may deviate from its share of slots before rebalancing takes place",
+				},
+				cli.IntFlag{
+					Name:  "pipeline, p",
+					Value: DefaultMigrationPipeline,
+					Usage: "number of keys moved by single MIGRATE command",
+				},
+				cli.IntFlag{
+					Name:  "timeout, t",
+					Value: DefaultMigrationTimeout,
+					Usage: "timeout of single MIGRATE command in milliseconds",
+				},
+				cli.BoolFlag{
+					Name:  "replace",
+					Usage: "replace keys which already exist on the target",
+				},
+			},
+			Action: func(c *cli.Context) {
+				err := controller.Rebalance(
+					first(c.Args()),
+					c.StringSlice("weight"),
+					c.Float64("threshold"),
+					MigrationOptions{
+						Pipeline: c.Int("pipeline"),
+						Timeout:  c.Int("timeout"),
+						Replace:  c.Bool("replace"),
+					})
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:        "failover",
+			Usage:       "Promotes the replica to master with CLUSTER FAILOVER",
+			Description: "Usage: rcm failover <cluster> (<replica port> | --of <master port> | --random) [--force|--takeover]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "of",
+					Usage: "fail over the master on specified port to one of its replicas",
+				},
+				cli.BoolFlag{
+					Name:  "random",
+					Usage: "fail over random master to one of its replicas",
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "do not wait for the master to agree. Useful when the master is down",
+				},
+				cli.BoolFlag{
+					Name:  "takeover",
+					Usage: "do not wait for the agreement of other masters",
+				},
+			},
+			Action: func(c *cli.Context) {
+				replicaPort, _ := strconv.Atoi(c.Args().Get(1))
+				err := controller.Failover(
+					first(c.Args()),
+					replicaPort,
+					c.Int("of"),
+					c.Bool("random"),
+					c.Bool("force"),
+					c.Bool("takeover"))
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:        "replicate",
+			Usage:       "Moves the replica under another master",
+			Description: "Usage: rcm replicate <cluster> <replica port> --of <master port> [--force] | rcm replicate <cluster> --auto-balance",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "of",
+					Usage: "port of the new master",
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "move the replica even if its master is left without replicas",
+				},
+				cli.BoolFlag{
+					Name:  "auto-balance",
+					Usage: "spread replicas evenly across masters",
+				},
+			},
+			Action: func(c *cli.Context) {
+				var err error
+
+				if c.Bool("auto-balance") {
+					err = controller.BalanceReplicas(first(c.Args()))
+				} else {
+					replicaPort, _ := strconv.Atoi(c.Args().Get(1))
+					err = controller.Replicate(first(c.Args()), replicaPort, c.Int("of"), c.Bool("force"))
+				}
+
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:  "fix",
+			Usage: "Repairs open slots, uncovered slots, nodes which don't know each other and stale failed nodes",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "pipeline, p",
+					Value: DefaultMigrationPipeline,
+					Usage: "number of keys moved by single MIGRATE command",
+				},
+				cli.IntFlag{
+					Name:  "timeout, t",
+					Value: DefaultMigrationTimeout,
+					Usage: "timeout of single MIGRATE command in milliseconds",
+				},
+			},
+			Action: func(c *cli.Context) {
+				err := controller.Fix(
+					first(c.Args()),
+					MigrationOptions{
+						Pipeline: c.Int("pipeline"),
+						Timeout:  c.Int("timeout"),
+					})
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:  "reset",
+			Usage: "Flushes and resets nodes so slots can be distributed again",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "hard",
+					Usage: "also give nodes new ids and reset their epochs",
+				},
+				cli.BoolFlag{
+					Name:  "yes, y",
+					Usage: "do not ask for confirmation",
+				},
+			},
+			Action: func(c *cli.Context) {
+				err := controller.Reset(first(c.Args()), c.Bool("hard"), c.Bool("yes"))
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:  "snapshot",
+			Usage: "Manages snapshots of data and configuration of the cluster",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:        "create",
+					Usage:       "Captures data and configuration of every node of the cluster",
+					Description: "Usage: rcm snapshot create <cluster> <snapshot>",
+					Action: func(c *cli.Context) {
+						err := controller.Snapshot(first(c.Args()), c.Args().Get(1))
+						printError(err)
+					},
+				},
+				cli.Command{
+					Name:        "ls",
+					Usage:       "Lists snapshots of the cluster",
+					Description: "Usage: rcm snapshot ls <cluster>",
+					Action: func(c *cli.Context) {
+						err := controller.Snapshots(first(c.Args()))
+						printError(err)
+					},
+				},
+				cli.Command{
+					Name:        "rm",
+					Usage:       "Removes the snapshot",
+					Description: "Usage: rcm snapshot rm <cluster> <snapshot>",
+					Action: func(c *cli.Context) {
+						err := controller.RemoveSnapshot(first(c.Args()), c.Args().Get(1))
+						printError(err)
+					},
+				},
+				cli.Command{
+					Name:        "restore",
+					Usage:       "Rolls the cluster back to the snapshot",
+					Description: "Usage: rcm snapshot restore <cluster> <snapshot>",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "yes, y",
+							Usage: "do not ask for confirmation",
+						},
+					},
+					Action: func(c *cli.Context) {
+						err := controller.Restore(first(c.Args()), c.Args().Get(1), c.Bool("yes"))
+						printError(err)
+					},
+				},
+			},
+		},
 		cli.Command{
 			Name:  "distribute-slots",
 			Usage: "Distributes slots in cluster",
@@ -132,9 +541,64 @@ func main() {
 					Value: 1,
 					Usage: "number of data replicas",
 				},
+				cli.StringFlag{
+					Name:  "spec",
+					Usage: "YAML file describing masters, replica counts and weights. Other options override it",
+				},
+				cli.StringSliceFlag{
+					Name:  "master, m",
+					Usage: "port of the node to become master. Slots are assigned in the given order (can be repeated)",
+				},
+				cli.StringSliceFlag{
+					Name:  "empty-master",
+					Usage: "port of the node to become master without slots (can be repeated)",
+				},
+				cli.StringSliceFlag{
+					Name:  "replica-count",
+					Usage: "number of replicas of the master in form <port>=<replicas> (can be repeated)",
+				},
+				cli.StringSliceFlag{
+					Name:  "weight, w",
+					Usage: "relative share of slots of the master in form <port>=<weight>. Default weight is 1 (can be repeated)",
+				},
+				cli.BoolFlag{
+					Name:  "no-anti-affinity",
+					Usage: "do not try to place masters and their replicas on different hosts",
+				},
+				cli.StringFlag{
+					Name:  "plan-out",
+					Usage: "save the computed plan to YAML file instead of applying it",
+				},
+				cli.StringFlag{
+					Name:  "plan-in",
+					Usage: "apply the plan from YAML file instead of computing it",
+				},
+				cli.BoolFlag{
+					Name:  "resume",
+					Usage: "complete the interrupted distribution applying only missing steps",
+				},
+				cli.BoolFlag{
+					Name:  "yes, y",
+					Usage: "do not ask for confirmation",
+				},
 			},
 			Action: func(c *cli.Context) {
-				err := controller.DistributeSlots(first(c.Args()), c.Int("replicas"), false)
+				err := controller.DistributeSlots(
+					first(c.Args()),
+					DistributionProperties{
+						replicas:       c.Int("replicas"),
+						replicasSet:    c.IsSet("replicas"),
+						specFile:       c.String("spec"),
+						masters:        c.StringSlice("master"),
+						emptyMasters:   c.StringSlice("empty-master"),
+						replicaCounts:  c.StringSlice("replica-count"),
+						weights:        c.StringSlice("weight"),
+						noAntiAffinity: c.Bool("no-anti-affinity"),
+						planIn:         c.String("plan-in"),
+						planOut:        c.String("plan-out"),
+						resume:         c.Bool("resume"),
+						sayYes:         c.Bool("yes"),
+					})
 				printError(err)
 			},
 		},
@@ -167,6 +631,15 @@ func main() {
 				printError(err)
 			},
 		},
+		cli.Command{
+			Name:        "env",
+			Usage:       "Prints cluster endpoints as shell variables",
+			Description: "Usage: eval $(rcm env <cluster>)",
+			Action: func(c *cli.Context) {
+				err := controller.Env(first(c.Args()))
+				printError(err)
+			},
+		},
 		cli.Command{
 			Name:        "damage",
 			Usage:       "Damage cluster to some degree",
@@ -207,6 +680,98 @@ func main() {
 				printError(err)
 			},
 		},
+		cli.Command{
+			Name:        "persistence",
+			Usage:       "Shows or switches persistence mode of the cluster",
+			Description: "Usage: rcm persistence <cluster> [rdb|aof|both|none]",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "save",
+					Usage: "RDB save point in form '<seconds> <changes>' (can be repeated)",
+				},
+				cli.StringFlag{
+					Name:  "appendfsync",
+					Usage: "AOF fsync policy (always, everysec or no)",
+				},
+			},
+			Action: func(c *cli.Context) {
+				err := controller.Persistence(first(c.Args()), c.Args().Get(1), c.StringSlice("save"), c.String("appendfsync"))
+				printError(err)
+			},
+		},
+		cli.Command{
+			Name:  "tls",
+			Usage: "Manages TLS certificates of the cluster",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:        "export",
+					Usage:       "Prints the path of the CA certificate to use in applications",
+					Description: "Usage: rcm tls export <cluster>",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "client, c",
+							Usage: "also print paths of the client certificate and key",
+						},
+					},
+					Action: func(c *cli.Context) {
+						err := controller.TlsExport(first(c.Args()), c.Bool("client"))
+						printError(err)
+					},
+				},
+			},
+		},
+		cli.Command{
+			Name:  "config",
+			Usage: "Manages redis.conf directives of the running cluster",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:        "set",
+					Usage:       "Sets the directive on running nodes and persists it in nodes' configuration",
+					Description: "Usage: rcm config set <cluster> <directive> <value>",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "node, n",
+							Usage: "port of the node to apply the directive to",
+						},
+						cli.StringFlag{
+							Name:  "role, r",
+							Usage: "role of the nodes to apply the directive to (master or slave)",
+						},
+					},
+					Action: func(c *cli.Context) {
+						args := c.Args()
+						var name, value string
+
+						if len(args) > 1 {
+							name = args[1]
+						}
+
+						if len(args) > 2 {
+							value = strings.Join(args[2:], " ")
+						}
+
+						err := controller.ConfigSet(first(args), name, value, c.Int("node"), c.String("role"))
+						printError(err)
+					},
+				},
+				cli.Command{
+					Name:        "get",
+					Usage:       "Shows the value of the directive across cluster nodes",
+					Description: "Usage: rcm config get <cluster> <directive>",
+					Action: func(c *cli.Context) {
+						args := c.Args()
+						var name string
+
+						if len(args) > 1 {
+							name = args[1]
+						}
+
+						err := controller.ConfigGet(first(args), name)
+						printError(err)
+					},
+				},
+			},
+		},
 		cli.Command{
 			Name:  "cli",
 			Usage: "Opens a redis-cli session with random cluster node",
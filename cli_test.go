@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRcmHomeDirIsAbsolute(t *testing.T) {
+	defer os.Setenv(RcmHomeEnv, os.Getenv(RcmHomeEnv))
+
+	os.Setenv(RcmHomeEnv, "relative/rcm")
+
+	home, err := rcmHomeDir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workDir, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if home != filepath.Join(workDir, "relative", "rcm") {
+		t.Errorf("Expected RCM_HOME to be made absolute but got %s", home)
+	}
+}
@@ -1,15 +1,98 @@
 package main
 
 import (
+	"fmt"
 	"math/rand"
+	"path"
 	"sort"
+	"sync"
 	"time"
 )
 
-const RedisSlotCount int = 16384
+const (
+	SlotPlanFileName            = "slots-plan.yml"
+	RedisSlotCount          int = 16384
+	ClusterOperationTimeout     = 30 * time.Second
+	clusterPollInterval         = 100 * time.Millisecond
+)
+
+func TimeoutError(operation string, lastErr error) error {
+	if lastErr != nil {
+		return fmt.Errorf("Timed out waiting for %s: %v", operation, lastErr)
+	}
+
+	return fmt.Errorf("Timed out waiting for %s", operation)
+}
+
+func SlotAssignedToOtherNodeError(slot int, address NodeAddress) error {
+	return fmt.Errorf("Slot %v is already served by %s", slot, address)
+}
+
+// Polls the condition until it is met. Errors returned by the condition abort waiting
+func waitUntil(operation string, timeout time.Duration, condition func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if ok, err := condition(); err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return TimeoutError(operation, nil)
+		}
+
+		time.Sleep(clusterPollInterval)
+	}
+}
+
+// Retries the action until it succeeds. Useful for commands which depend on gossip propagation
+func retryUntil(operation string, timeout time.Duration, action func() error) error {
+	var lastErr error
+
+	err := waitUntil(operation, timeout, func() (bool, error) {
+		lastErr = action()
+		return lastErr == nil, nil
+	})
+
+	if err != nil {
+		return TimeoutError(operation, lastErr)
+	}
+
+	return nil
+}
+
+// Runs actions concurrently and waits for all of them to complete. The first error is returned
+func parallel(actions []func() error) error {
+	errs := make(chan error, len(actions))
+	var wg sync.WaitGroup
+
+	for _, action := range actions {
+		wg.Add(1)
+
+		go func(action func() error) {
+			defer wg.Done()
+			errs <- action()
+		}(action)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
 type Cluster struct {
-	nodes []*Node
+	baseDir string
+	conf    *ClusterConf
+	nodes   []*Node
 }
 
 type ClusterStats struct {
@@ -20,29 +103,104 @@ type ClusterStats struct {
 type Shard struct {
 	MasterAddress   NodeAddress
 	SlavesAddresses []NodeAddress
-	FromSlot        int
-	ToSlot          int
+	Slots           []SlotRange
 	masterIndex     int
 	slaveIndices    []int
 }
 
+func (self Shard) SlotCount() int {
+	result := 0
+
+	for _, slotRange := range self.Slots {
+		result += slotRange.Count()
+	}
+
+	return result
+}
+
 func NewCluster(baseDir string, conf *ClusterConf, binaries *Binaries) *Cluster {
 
 	nodes := make([]*Node, len(conf.ListenPorts))
+	binaries = binaries.With(conf.Binaries)
 
 	for i, port := range conf.ListenPorts {
 		nodes[i] = NewNode(baseDir, port, conf, binaries)
 	}
 
 	return &Cluster{
-		nodes: nodes,
+		baseDir: baseDir,
+		conf:    conf,
+		nodes:   nodes,
+	}
+}
+
+func (self *Cluster) Conf() *ClusterConf {
+	return self.conf
+}
+
+func (self *Cluster) CreateNodes() error {
+	for _, node := range self.nodes {
+		if err := node.Create(); err != nil {
+			return err
+		}
 	}
+
+	return nil
+}
+
+func (self *Cluster) TlsCa() TlsFiles {
+	return ClusterTlsCa(self.baseDir)
+}
+
+func (self *Cluster) TlsClient() TlsFiles {
+	return ClusterTlsClient(self.baseDir)
 }
 
-func (self *Cluster) CreateNodes() {
+// Regenerates configuration files of the nodes. Nodes which have no directories yet are created
+func (self *Cluster) SaveNodesConf() error {
 	for _, node := range self.nodes {
-		node.Create()
+		if node.Exists() {
+			if err := node.SaveConf(); err != nil {
+				return err
+			}
+		} else if err := node.Create(); err != nil {
+			return err
+		}
 	}
+
+	return nil
+}
+
+func (self *Cluster) NodeByPort(port int) (*Node, bool) {
+	for _, node := range self.nodes {
+		if node.Port() == port {
+			return node, true
+		}
+	}
+
+	return nil, false
+}
+
+// Returns the node which is described by `cluster nodes` record. Ports are unique within the cluster
+func (self *Cluster) NodeOf(info ClusterNodeInfo) (*Node, bool) {
+	return self.NodeByPort(info.Address.Port)
+}
+
+// Returns any running node except specified one or nil if there is no such node
+func (self *Cluster) OtherUpNode(except *Node) (*Node, error) {
+	for _, node := range self.nodes {
+		if node == except {
+			continue
+		}
+
+		if isUp, err := node.IsUp(); err != nil {
+			return nil, err
+		} else if isUp {
+			return node, nil
+		}
+	}
+
+	return nil, nil
 }
 
 func (self *Cluster) Nodes() []*Node {
@@ -122,6 +280,57 @@ func (self *Cluster) Stop() error {
 	return err
 }
 
+// Starts nodes which are down and waits until every node responds
+func (self *Cluster) StartAndWait() error {
+	for _, node := range self.nodes {
+		if isUp, err := node.IsUp(); err != nil {
+			return err
+		} else if isUp {
+			continue
+		}
+
+		if err := node.Start(); err != nil {
+			return err
+		}
+	}
+
+	for _, node := range self.nodes {
+		if err := retryUntil(fmt.Sprintf("node %s to start", node.Address()), ClusterOperationTimeout, node.Ping); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stops nodes which are up and waits until they exit
+func (self *Cluster) StopAndWait() error {
+	for _, node := range self.nodes {
+		if isUp, err := node.IsUp(); err != nil {
+			return err
+		} else if !isUp {
+			continue
+		}
+
+		if err := node.Stop(); err != nil {
+			return err
+		}
+	}
+
+	for _, node := range self.nodes {
+		err := waitUntil(fmt.Sprintf("node %s to stop", node.Address()), ClusterOperationTimeout, func() (bool, error) {
+			isUp, err := node.IsUp()
+			return !isUp, err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (self *Cluster) Kill() error {
 	var err error = nil
 
@@ -165,87 +374,410 @@ func (self Cluster) Stats() (*ClusterStats, error) {
 	return &result, nil
 }
 
-func (self *Cluster) PrepareSlotDistribution(replicas int) []Shard {
-	if replicas < 0 {
-		panic("Number of replicas should be greater than zero")
+// Computes shards according to the spec. Slots are split between masters in proportion to their weights
+func (self *Cluster) PrepareSlotDistribution(spec SlotDistributionSpec) ([]Shard, error) {
+	ports := make([]int, len(self.nodes))
+
+	for i, node := range self.nodes {
+		ports[i] = node.Port()
 	}
 
-	nodesWithDataCount := replicas + 1
+	masterPorts, err := spec.masterPorts(ports)
+
+	if err != nil {
+		return nil, err
+	}
 
-	nodesCount := len(self.nodes)
+	weights := make([]float64, len(masterPorts))
 
-	if nodesWithDataCount > nodesCount {
-		panic("Number of replicas should be less then nodes count")
+	for i, port := range masterPorts {
+		weights[i] = spec.weightOf(port)
 	}
 
-	mastersCount := nodesCount / nodesWithDataCount
+	slotCounts, err := proportionalShares(RedisSlotCount, weights)
 
-	result := make([]Shard, mastersCount)
+	if err != nil {
+		return nil, err
+	}
 
-	slotsPerShard := RedisSlotCount / mastersCount
+	result := make([]Shard, len(masterPorts))
+	fromSlot := 0
+
+	for i, port := range masterPorts {
+		node, _ := self.NodeByPort(port)
 
-	for i, node := range self.nodes[:mastersCount] {
 		result[i].MasterAddress = node.Address()
-		result[i].masterIndex = i
+		result[i].masterIndex = self.nodeIndex(node)
+
+		if slotCounts[i] > 0 {
+			result[i].Slots = []SlotRange{{From: fromSlot, To: fromSlot + slotCounts[i] - 1}}
+		}
+
+		fromSlot += slotCounts[i]
+	}
+
+	slaveIndices := []int{}
+
+	for i, port := range ports {
+		if !containsPort(masterPorts, port) {
+			slaveIndices = append(slaveIndices, i)
+		}
+	}
 
-		fromSlot := slotsPerShard * i
+	required := 0
 
-		result[i].FromSlot = fromSlot
-		result[i].ToSlot = fromSlot + slotsPerShard
+	for _, count := range spec.ReplicaCounts {
+		required += count
 	}
 
-	result[mastersCount-1].ToSlot = RedisSlotCount
+	if required > len(slaveIndices) {
+		return nil, NotEnoughReplicasError(required, len(slaveIndices))
+	}
+
+	roundRobin := []int{}
+
+	for i, port := range masterPorts {
+		if count, ok := spec.ReplicaCounts[port]; ok {
+			result[i].slaveIndices = append([]int{}, slaveIndices[:count]...)
+			slaveIndices = slaveIndices[count:]
+		} else if result[i].SlotCount() > 0 {
+			roundRobin = append(roundRobin, i)
+		}
+	}
+
+	if len(slaveIndices) > 0 && len(roundRobin) < 1 {
+		return nil, UnassignedNodesError(len(slaveIndices))
+	}
+
+	for j, slaveIndex := range slaveIndices {
+		i := roundRobin[j%len(roundRobin)]
+		result[i].slaveIndices = append(result[i].slaveIndices, slaveIndex)
+	}
+
+	if spec.AntiAffinity {
+		self.spreadReplicasAcrossHosts(result)
+	}
 
-	for j, node := range self.nodes[mastersCount:] {
-		i := j % mastersCount
-		result[i].SlavesAddresses = append(result[i].SlavesAddresses, node.Address())
-		result[i].slaveIndices = append(result[i].slaveIndices, mastersCount+j)
+	for i, shard := range result {
+		for _, slaveIndex := range shard.slaveIndices {
+			result[i].SlavesAddresses = append(result[i].SlavesAddresses, self.nodes[slaveIndex].Address())
+		}
+	}
+
+	return result, nil
+}
+
+func (self *Cluster) nodeIndex(node *Node) int {
+	for i, n := range self.nodes {
+		if n == node {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Swaps replicas between shards while it reduces the number of replicas sharing the host with their master or with
+// other replicas of the same shard. Each shard keeps the number of replicas it had
+func (self *Cluster) spreadReplicasAcrossHosts(shards []Shard) {
+	for improved := true; improved; {
+		improved = false
+
+		for a := range shards {
+			for b := a + 1; b < len(shards); b++ {
+				for i := range shards[a].slaveIndices {
+					for j := range shards[b].slaveIndices {
+						before := self.hostPenalty(shards[a]) + self.hostPenalty(shards[b])
+
+						sa, sb := &shards[a].slaveIndices[i], &shards[b].slaveIndices[j]
+						*sa, *sb = *sb, *sa
+
+						if after := self.hostPenalty(shards[a]) + self.hostPenalty(shards[b]); after < before {
+							improved = true
+						} else {
+							*sa, *sb = *sb, *sa
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (self *Cluster) hostPenalty(shard Shard) int {
+	result := 0
+
+	for i, slaveIndex := range shard.slaveIndices {
+		host := self.nodes[slaveIndex].Host()
+
+		if self.nodes[shard.masterIndex].Host() == host {
+			result += 2
+		}
+
+		for _, otherSlaveIndex := range shard.slaveIndices[i+1:] {
+			if self.nodes[otherSlaveIndex].Host() == host {
+				result += 1
+			}
+		}
 	}
 
 	return result
 }
 
+// Starts new nodes and introduces them to the cluster through the seed node. When master is specified new nodes are
+// attached to it as replicas
+func (self *Cluster) JoinNodes(seed *Node, nodes []*Node, master *Node) error {
+	for _, node := range nodes {
+		if err := node.Create(); err != nil {
+			return err
+		}
+
+		if err := node.Start(); err != nil {
+			return err
+		}
+	}
+
+	for _, node := range nodes {
+		if err := retryUntil(fmt.Sprintf("node %s to start", node.Address()), ClusterOperationTimeout, node.Ping); err != nil {
+			return err
+		}
+
+		if err := seed.ClusterMeet(node.Address()); err != nil {
+			return err
+		}
+	}
+
+	if master == nil {
+		return nil
+	}
+
+	masterId, err := master.Id()
+
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		err := retryUntil(
+			fmt.Sprintf("node %s to replicate %s", node.Address(), master.Address()),
+			ClusterOperationTimeout,
+			func() error {
+				return node.ClusterReplicate(masterId)
+			})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns masters and replicas of the shards
+func (self *Cluster) NodesOf(shards []Shard) []*Node {
+	result := []*Node{}
+
+	for _, shard := range shards {
+		result = append(result, self.nodes[shard.masterIndex])
+
+		for _, slaveIndex := range shard.slaveIndices {
+			result = append(result, self.nodes[slaveIndex])
+		}
+	}
+
+	return result
+}
+
+// Waits until each node knows every other node and the handshake is over
+func (self *Cluster) WaitUntilNodesKnown(nodes []*Node) error {
+	waits := make([]func() error, len(nodes))
+
+	for i, node := range nodes {
+		node := node
+
+		waits[i] = func() error {
+			return waitUntil(fmt.Sprintf("node %s to know all other nodes", node.Address()), ClusterOperationTimeout, func() (bool, error) {
+				topology, err := node.ClusterTopology()
+
+				if err != nil {
+					return false, err
+				}
+
+				for _, other := range nodes {
+					if info, ok := topology.ByPort(other.Port()); !ok || info.HasFlag(NodeFlagHandshake) {
+						return false, nil
+					}
+				}
+
+				return true, nil
+			})
+		}
+	}
+
+	return parallel(waits)
+}
+
+// Waits until replicas of the shards report their link to the master is up
+func (self *Cluster) WaitUntilReplicasLinked(shards []Shard) error {
+	waits := []func() error{}
+
+	for _, shard := range shards {
+		for _, slaveIndex := range shard.slaveIndices {
+			node := self.nodes[slaveIndex]
+
+			waits = append(waits, func() error {
+				return waitUntil(fmt.Sprintf("replica %s to link to master", node.Address()), ClusterOperationTimeout, func() (bool, error) {
+					info, err := node.Info("replication")
+					return err == nil && info["master_link_status"] == "up", err
+				})
+			})
+		}
+	}
+
+	return parallel(waits)
+}
+
+// Waits until each node reports cluster_state:ok
+func (self *Cluster) WaitUntilClusterOk(nodes []*Node) error {
+	waits := make([]func() error, len(nodes))
+
+	for i, node := range nodes {
+		node := node
+
+		waits[i] = func() error {
+			return waitUntil(fmt.Sprintf("node %s to report cluster state ok", node.Address()), ClusterOperationTimeout, func() (bool, error) {
+				info, err := node.ClusterInfoFields()
+				return err == nil && info["cluster_state"] == "ok", err
+			})
+		}
+	}
+
+	return parallel(waits)
+}
+
+// Joins nodes of the shards, assigns slots to masters and attaches replicas. Commands of each stage are sent to all
+// nodes concurrently. The current topology is inspected first so only missing steps are applied and the distribution
+// interrupted halfway can be applied again
 func (self *Cluster) ApplySlotDistribution(shards []Shard) error {
 	firstNode := self.nodes[shards[0].masterIndex]
+	known, err := firstNode.ClusterTopology()
 
-	for _, shard := range shards[1:] {
-		meetErr := firstNode.ClusterMeet(shard.MasterAddress).Run()
+	if err != nil {
+		return err
+	}
+
+	meets := []func() error{}
+
+	for i, shard := range shards {
+		addresses := shard.SlavesAddresses
+
+		if i > 0 {
+			addresses = append([]NodeAddress{shard.MasterAddress}, addresses...)
+		}
+
+		for _, address := range addresses {
+			address := address
+
+			if _, ok := known.ByPort(address.Port); ok {
+				continue
+			}
 
-		if meetErr != nil {
-			return meetErr
+			meets = append(meets, func() error {
+				return firstNode.ClusterMeet(address)
+			})
 		}
 	}
 
+	if err := parallel(meets); err != nil {
+		return err
+	}
+
+	owners, err := self.slotOwners(shards)
+
+	if err != nil {
+		return err
+	}
+
+	addSlots := []func() error{}
+	replicates := []func() error{}
+
 	for _, shard := range shards {
 		masterNode := self.nodes[shard.masterIndex]
-		masterNodeId, masterNodeIdErr := masterNode.Id()
+		missing := []int{}
 
-		if masterNodeIdErr != nil {
-			return masterNodeIdErr
+		for _, slot := range SlotsOf(shard.Slots) {
+			if owner, ok := owners[slot]; !ok {
+				missing = append(missing, slot)
+			} else if owner != masterNode {
+				return SlotAssignedToOtherNodeError(slot, owner.Address())
+			}
 		}
 
-		addSlotsErr := masterNode.ClusterAddSlots(shard.FromSlot, shard.ToSlot).Run()
+		for _, slotRange := range SlotRangesOf(missing) {
+			fromSlot, toSlot := slotRange.From, slotRange.To+1
 
-		if addSlotsErr != nil {
-			return addSlotsErr
+			addSlots = append(addSlots, func() error {
+				return masterNode.ClusterAddSlots(fromSlot, toSlot)
+			})
 		}
 
 		for _, slaveIndex := range shard.slaveIndices {
 			slaveNode := self.nodes[slaveIndex]
 
-			clusterMeetErr := masterNode.ClusterMeet(slaveNode.Address()).Run()
+			replicates = append(replicates, func() error {
+				masterNodeId, err := masterNode.Id()
+
+				if err != nil {
+					return err
+				}
+
+				if topology, err := slaveNode.ClusterTopology(); err != nil {
+					return err
+				} else if myself, ok := topology.Myself(); ok && myself.MasterId == masterNodeId {
+					return nil
+				}
+
+				// The replica learns about the master through gossip so the command is retried until then
+				return retryUntil(
+					fmt.Sprintf("node %s to replicate %s", slaveNode.Address(), masterNode.Address()),
+					ClusterOperationTimeout,
+					func() error {
+						return slaveNode.ClusterReplicate(masterNodeId)
+					})
+			})
+		}
+	}
 
-			if clusterMeetErr != nil {
-				return clusterMeetErr
-			}
+	if err := parallel(addSlots); err != nil {
+		return err
+	}
 
-			clusterReplicateErr := slaveNode.ClusterReplicate(masterNodeId).Run()
+	return parallel(replicates)
+}
+
+// Returns masters of the shards which already serve slots as they report it themselves
+func (self *Cluster) slotOwners(shards []Shard) (map[int]*Node, error) {
+	result := make(map[int]*Node)
+
+	for _, shard := range shards {
+		node := self.nodes[shard.masterIndex]
+		topology, err := node.ClusterTopology()
 
-			if clusterReplicateErr != nil {
-				return clusterReplicateErr
+		if err != nil {
+			return nil, err
+		}
+
+		if myself, ok := topology.Myself(); ok {
+			for _, slot := range SlotsOf(myself.Slots) {
+				result[slot] = node
 			}
 		}
 	}
 
-	return nil
+	return result, nil
+}
+
+// File the plan is saved to while it is being applied so the interrupted distribution can be resumed
+func (self *Cluster) SlotPlanFile() string {
+	return path.Join(self.baseDir, SlotPlanFileName)
 }
@@ -41,8 +41,109 @@ func (self *ClusterSet) Create(name string, conf *ClusterConf) (*Cluster, error)
 		return nil, err
 	}
 
+	if conf.Tls {
+		ca := ClusterTlsCa(self.clusterBaseDir(name))
+
+		if err := GenerateTlsCa(ca, fmt.Sprintf("RCM %s CA", name)); err != nil {
+			return nil, err
+		}
+
+		client := ClusterTlsClient(self.clusterBaseDir(name))
+
+		if err := GenerateTlsCert(ca, client, "rcm", tlsHosts(conf.BindAddresses()...)); err != nil {
+			return nil, err
+		}
+	}
+
 	result := NewCluster(self.clusterBaseDir(name), conf, self.binaries)
-	result.CreateNodes()
+
+	if err := result.CreateNodes(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Copies the stopped cluster under the new name moving its nodes to new ports. The clone keeps the topology and the
+// data of the source
+func (self *ClusterSet) Clone(srcName string, dstName string, portMap map[int]int) (*Cluster, error) {
+	src, err := self.Open(srcName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if self.Exists(dstName) {
+		return nil, errors.New(fmt.Sprintf("Cluster %s already exists", dstName))
+	}
+
+	conf := src.Conf().WithPorts(portMap)
+	result := NewCluster(self.clusterBaseDir(dstName), conf, self.binaries)
+
+	err = func() error {
+		if err := os.MkdirAll(self.clusterBaseDir(dstName), 0750); err != nil {
+			return err
+		}
+
+		if conf.Tls {
+			srcTlsDir := path.Join(self.clusterBaseDir(srcName), TlsDirName)
+
+			if err := copyDir(srcTlsDir, path.Join(self.clusterBaseDir(dstName), TlsDirName)); err != nil {
+				return err
+			}
+		}
+
+		for _, node := range src.Nodes() {
+			target, _ := result.NodeByPort(portMap[node.Port()])
+
+			if err := node.CopyTo(target, portMap); err != nil {
+				return err
+			}
+		}
+
+		return SaveClusterConf(self.clusterConfFile(dstName), conf)
+	}()
+
+	if err != nil {
+		os.RemoveAll(self.clusterBaseDir(dstName))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Renames the stopped cluster. Configuration of the nodes is regenerated since it refers to the cluster directory
+func (self *ClusterSet) Rename(oldName string, newName string) (*Cluster, error) {
+	src, err := self.Open(oldName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if self.Exists(newName) {
+		return nil, errors.New(fmt.Sprintf("Cluster %s already exists", newName))
+	}
+
+	result := NewCluster(self.clusterBaseDir(newName), src.Conf(), self.binaries)
+
+	for _, node := range result.Nodes() {
+		if len(node.UnixSocket()) > MaxUnixSocketPathLength {
+			return nil, UnixSocketPathTooLongError(node.UnixSocket())
+		}
+	}
+
+	if err := os.Rename(self.clusterBaseDir(oldName), self.clusterBaseDir(newName)); err != nil {
+		return nil, err
+	}
+
+	if err := result.SaveNodesConf(); err != nil {
+		// Configuration which has been regenerated already points to the new directory so it is regenerated again
+		if os.Rename(self.clusterBaseDir(newName), self.clusterBaseDir(oldName)) == nil {
+			src.SaveNodesConf()
+		}
+
+		return nil, err
+	}
 
 	return result, nil
 }
@@ -65,6 +166,31 @@ func (self *ClusterSet) Open(name string) (*Cluster, error) {
 	}
 }
 
+// Saves the updated configuration of the cluster and regenerates configuration files of its nodes
+func (self *ClusterSet) Update(name string, conf *ClusterConf) (*Cluster, error) {
+
+	if !self.Exists(name) {
+		return nil, errors.New(fmt.Sprintf("Cluster %s not exists", name))
+	}
+
+	result := NewCluster(self.clusterBaseDir(name), conf, self.binaries)
+
+	if err := result.SaveNodesConf(); err != nil {
+		return nil, err
+	}
+
+	if err := SaveClusterConf(self.clusterConfFile(name), conf); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Returns the cluster with the configuration which is not saved yet
+func (self *ClusterSet) Prepare(name string, conf *ClusterConf) *Cluster {
+	return NewCluster(self.clusterBaseDir(name), conf, self.binaries)
+}
+
 func (self *ClusterSet) Remove(name string) error {
 	return os.RemoveAll(self.clusterBaseDir(name))
 }
@@ -82,6 +208,25 @@ func (self *ClusterSet) ListNames() ([]string, error) {
 	}
 }
 
+// Returns ports of all nodes of all known clusters
+func (self *ClusterSet) UsedPorts() ([]int, error) {
+	names, err := self.ListNames()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []int{}
+
+	for _, name := range names {
+		if conf, err := LoadClusterConf(self.clusterConfFile(name)); err == nil {
+			result = append(result, conf.ListenPorts...)
+		}
+	}
+
+	return result, nil
+}
+
 func (self *ClusterSet) clusterBaseDir(name string) string {
 	return path.Join(self.baseDir, name)
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestClusterSetClone(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_set_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	clusterSet, err := NewClusterSet(tmpdir, &Binaries{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &ClusterConf{
+		ListenIp:       "127.0.0.1",
+		ListenPorts:    []int{9001, 9002},
+		NodeDirectives: map[int]map[string]string{9002: {"maxmemory": "10mb"}},
+		Distribution:   &SlotDistributionSpec{Masters: []int{9001}, ReplicaCounts: map[int]int{9001: 1}},
+	}
+
+	src, err := clusterSet.Create("src", conf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcNode, _ := src.NodeByPort(9001)
+	nodesConf := "a1 127.0.0.1:9001@19001 myself,master - 0 0 1 connected 0-16383\n" +
+		"b2 127.0.0.1:9002@19002 slave a1 0 0 1 connected\n"
+
+	if err := ioutil.WriteFile(srcNode.NodesConfFile(), []byte(nodesConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := clusterSet.Clone("src", "dst", map[int]int{9001: 9101, 9002: 9102})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := dst.NodeByPort(9101); !ok {
+		t.Fatalf("Expected clone to have node on port 9101")
+	}
+
+	if loaded, err := LoadClusterConf(clusterSet.clusterConfFile("dst")); err != nil {
+		t.Fatal(err)
+	} else if loaded.NodeDirectives[9102]["maxmemory"] != "10mb" {
+		t.Errorf("Expected node directives to follow the node to the new port but got %v", loaded.NodeDirectives)
+	} else if loaded.Distribution.Masters[0] != 9101 || loaded.Distribution.ReplicaCounts[9101] != 1 {
+		t.Errorf("Expected distribution to follow the nodes to the new ports but got %+v", loaded.Distribution)
+	}
+
+	dstNode, _ := dst.NodeByPort(9101)
+
+	if content, err := ioutil.ReadFile(dstNode.NodesConfFile()); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(content), "127.0.0.1:9101@19101") || strings.Contains(string(content), ":9002") {
+		t.Errorf("Expected addresses to be rewritten but got\n%s", content)
+	}
+
+	if content, err := ioutil.ReadFile(dstNode.confFilePath); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(content), "port 9101") || strings.Contains(string(content), "/src/") {
+		t.Errorf("Expected configuration to be regenerated but got\n%s", content)
+	}
+
+	if conf.ListenPorts[0] != 9001 {
+		t.Errorf("Expected configuration of the source to be left intact")
+	}
+}
+
+func TestClusterSetRename(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_set_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	clusterSet, err := NewClusterSet(tmpdir, &Binaries{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := clusterSet.Create("old", &ClusterConf{ListenIp: "127.0.0.1", ListenPorts: []int{9001, 9002}}); err != nil {
+		t.Fatal(err)
+	}
+
+	renamed, err := clusterSet.Rename("old", "new")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if clusterSet.Exists("old") || !clusterSet.Exists("new") {
+		t.Errorf("Expected cluster directory to be renamed")
+	}
+
+	node, _ := renamed.NodeByPort(9001)
+
+	if content, err := ioutil.ReadFile(node.confFilePath); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(content), "/old/") {
+		t.Errorf("Expected configuration to refer to the new directory but got\n%s", content)
+	}
+}
+
+func TestClusterSetUpdateAddsPorts(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_set_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	clusterSet, err := NewClusterSet(tmpdir, &Binaries{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &ClusterConf{
+		ListenIp:    "127.0.0.1",
+		ListenPorts: []int{9001, 9002},
+		Password:    "secret",
+		Users:       []AclUser{AclUser{Name: "app", Password: "secret"}},
+	}
+
+	if _, err := clusterSet.Create("test", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	extended := conf.WithPorts(map[int]int{})
+	extended.ListenPorts = append(extended.ListenPorts, 9003)
+
+	cluster, err := clusterSet.Update("test", extended)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, ok := cluster.NodeByPort(9003)
+
+	if !ok {
+		t.Fatalf("Expected node on port 9003")
+	}
+
+	if content, err := ioutil.ReadFile(node.confFilePath); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(content), "port 9003") {
+		t.Errorf("Expected configuration of the new node to be generated but got\n%s", content)
+	}
+
+	if loaded, err := LoadClusterConf(clusterSet.clusterConfFile("test")); err != nil {
+		t.Fatal(err)
+	} else if len(loaded.ListenPorts) != 3 {
+		t.Errorf("Expected the new port to be saved but got %v", loaded.ListenPorts)
+	}
+
+	if len(conf.ListenPorts) != 2 {
+		t.Errorf("Expected the original configuration to be left intact but got %v", conf.ListenPorts)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrepareSlotDistributionCoversAllSlots(t *testing.T) {
+	for nodesCount := 2; nodesCount <= 15; nodesCount++ {
+		cluster := newTestCluster(nodesCount, 1)
+
+		for replicas := 0; replicas < nodesCount; replicas++ {
+			shards, err := cluster.PrepareSlotDistribution(newTestSpec(replicas))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nextSlot := 0
+			nodesUsed := 0
+
+			for _, shard := range shards {
+				if len(shard.Slots) != 1 || shard.Slots[0].From != nextSlot {
+					t.Fatalf("Expected shard to start from slot %v but got %v", nextSlot, shard.Slots)
+				}
+
+				nextSlot = shard.Slots[0].To + 1
+				nodesUsed += 1 + len(shard.slaveIndices)
+			}
+
+			if nextSlot != RedisSlotCount {
+				t.Errorf("Expected slots to be covered up to %v but got %v", RedisSlotCount, nextSlot)
+			}
+
+			if nodesUsed != nodesCount {
+				t.Errorf("Expected all %v nodes to be used but got %v", nodesCount, nodesUsed)
+			}
+		}
+	}
+}
+
+func TestPrepareSlotDistributionAntiAffinity(t *testing.T) {
+	cases := []struct {
+		nodesCount int
+		hostsCount int
+		replicas   int
+	}{
+		{6, 3, 1},
+		{6, 2, 1},
+		{9, 3, 2},
+		{12, 4, 2},
+		{8, 4, 1},
+	}
+
+	for _, c := range cases {
+		cluster := newTestCluster(c.nodesCount, c.hostsCount)
+		shards, err := cluster.PrepareSlotDistribution(newTestSpec(c.replicas))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, shard := range shards {
+			hosts := map[string]bool{cluster.nodes[shard.masterIndex].Host(): true}
+
+			for _, slaveIndex := range shard.slaveIndices {
+				host := cluster.nodes[slaveIndex].Host()
+
+				if hosts[host] {
+					t.Errorf(
+						"%v nodes on %v hosts with %v replicas: shard of %v has several nodes on host %v",
+						c.nodesCount, c.hostsCount, c.replicas, shard.MasterAddress, host)
+				}
+
+				hosts[host] = true
+			}
+
+			if len(shard.SlavesAddresses) != len(shard.slaveIndices) {
+				t.Errorf("Expected replica addresses to match replica indices")
+			}
+		}
+	}
+}
+
+func TestSplitSlots(t *testing.T) {
+	slots := SlotsOf([]SlotRange{SlotRange{0, 99}})
+	chunks := splitSlots(slots, []int{100, 50, 0})
+
+	counts := []int{100 + len(chunks[0]), 50 + len(chunks[1]), len(chunks[2])}
+
+	if !reflect.DeepEqual(counts, []int{100, 75, 75}) {
+		t.Errorf("Expected slots to be evened out but got %v", counts)
+	}
+
+	next := 0
+
+	for _, chunk := range chunks {
+		for _, slot := range chunk {
+			if slot != next {
+				t.Fatalf("Expected chunks to be contiguous")
+			}
+
+			next++
+		}
+	}
+}
+
+func TestPrepareSlotDistributionWithExplicitLayout(t *testing.T) {
+	cluster := newTestCluster(8, 1)
+
+	spec := newTestSpec(1)
+	spec.Masters = []int{9003, 9001}
+	spec.EmptyMasters = []int{9008}
+	spec.ReplicaCounts = map[int]int{9003: 3}
+	spec.Weights = map[int]float64{9001: 3}
+
+	shards, err := cluster.PrepareSlotDistribution(spec)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(shards) != 3 {
+		t.Fatalf("Expected 3 shards but got %v", len(shards))
+	}
+
+	ports := []int{shards[0].MasterAddress.Port, shards[1].MasterAddress.Port, shards[2].MasterAddress.Port}
+
+	if !reflect.DeepEqual(ports, []int{9003, 9001, 9008}) {
+		t.Errorf("Expected masters in the given order but got %v", ports)
+	}
+
+	slotCounts := []int{}
+	replicaCounts := []int{}
+
+	for _, shard := range shards {
+		slotCounts = append(slotCounts, shard.SlotCount())
+		replicaCounts = append(replicaCounts, len(shard.SlavesAddresses))
+	}
+
+	if !reflect.DeepEqual(slotCounts, []int{4096, 12288, 0}) {
+		t.Errorf("Expected slots to be split by weights but got %v", slotCounts)
+	}
+
+	if !reflect.DeepEqual(replicaCounts, []int{3, 2, 0}) {
+		t.Errorf("Expected explicit replica counts to be respected but got %v", replicaCounts)
+	}
+}
+
+func TestPrepareSlotDistributionRejectsIllegalSpec(t *testing.T) {
+	cluster := newTestCluster(6, 1)
+
+	cases := []SlotDistributionSpec{
+		{Replicas: -1},
+		{Replicas: 6},
+		{Masters: []int{9001, 9001}},
+		{Masters: []int{9100}},
+		{ReplicaCounts: map[int]int{9001: 6}},
+		{Masters: []int{9001}, ReplicaCounts: map[int]int{9001: 1}},
+		{Masters: []int{9001}, Weights: map[int]float64{9002: 1}},
+		{Masters: []int{9001}, Weights: map[int]float64{9001: -1}},
+		{Masters: []int{9001}, EmptyMasters: []int{9001}},
+	}
+
+	for i, spec := range cases {
+		if _, err := cluster.PrepareSlotDistribution(spec); err == nil {
+			t.Errorf("Expected error for case %v", i)
+		}
+	}
+}
+
+// Supporting code
+
+func newTestCluster(nodesCount int, hostsCount int) *Cluster {
+	ports := make([]int, nodesCount)
+
+	for i := range ports {
+		ports[i] = 9001 + i
+	}
+
+	conf := &ClusterConf{ListenIp: "127.0.0.1", ListenPorts: ports}
+
+	if hostsCount > 1 {
+		conf.NodeBind, _ = SimulatedHostsBind(conf.ListenIp, ports, hostsCount)
+	}
+
+	return NewCluster("/tmp/rcm_cluster_test", conf, &Binaries{})
+}
+
+func newTestSpec(replicas int) SlotDistributionSpec {
+	spec := DefaultSlotDistributionSpec()
+	spec.Replicas = replicas
+	return spec
+}
@@ -2,16 +2,610 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+const (
+	DefaultRedisLogLevel  string = "notice"
+	DefaultAppendFsync    string = "everysec"
+	DefaultAclRules       string = "~* +@all"
+	AclChannelRules       string = "&*"
+	DefaultAclUser        string = "default"
+	DefaultUnixSocketPerm string = "700"
+
+	PersistenceNone string = "none"
+	PersistenceRdb  string = "rdb"
+	PersistenceAof  string = "aof"
+	PersistenceBoth string = "both"
+)
+
+var (
+	persistenceModes    = []string{PersistenceNone, PersistenceRdb, PersistenceAof, PersistenceBoth}
+	appendFsyncPolicies = []string{"always", "everysec", "no"}
+
+	// The same schedule Redis uses when no save points are configured
+	DefaultRdbSaveSchedule = []string{"3600 1", "300 100", "60 10000"}
+
+	BindAddressRequiredError = errors.New("At least one bind address is required")
+
+	secretRegEx   = regexp.MustCompile(`^[^\s"'>]+$`)
+	userNameRegEx = regexp.MustCompile(`^[\w+\-\.@]+$`)
+)
+
+// Directives which are generated by rcm itself and can't be overridden by the user
+var managedRedisDirectives = map[string]bool{
+	"include":              true,
+	"daemonize":            true,
+	"cluster-enabled":      true,
+	"cluster-config-file":  true,
+	"bind":                 true,
+	"port":                 true,
+	"pidfile":              true,
+	"logfile":              true,
+	"dir":                  true,
+	"appendonly":           true,
+	"appendfsync":          true,
+	"aof-use-rdb-preamble": true,
+	"save":                 true,
+	"requirepass":          true,
+	"masterauth":           true,
+	"masteruser":           true,
+	"aclfile":              true,
+	"tls-port":             true,
+	"tls-cert-file":        true,
+	"tls-key-file":         true,
+	"tls-ca-cert-file":     true,
+	"tls-cluster":          true,
+	"tls-replication":      true,
+	"unixsocket":           true,
+}
+
+// Directives which take effect every time they occur. Explicit values are added to the ones of the base file
+var repeatableRedisDirectives = map[string]bool{
+	"rename-command":             true,
+	"client-output-buffer-limit": true,
+	"loadmodule":                 true,
+}
+
+func ManagedDirectiveError(name string) error {
+	return fmt.Errorf("Directive '%s' is managed by rcm and can't be overridden", name)
+}
+
+func IllegalDirectiveError(directive string) error {
+	return fmt.Errorf("Illegal directive '%s'. Should be in form key=value", directive)
+}
+
+func IllegalBindAddressError(address string) error {
+	return fmt.Errorf("Illegal bind address '%s'. Should be IPv4 or IPv6 address or resolvable host name", address)
+}
+
+func DuplicateBindAddressError(address string) error {
+	return fmt.Errorf("Bind address '%s' is specified more than once", address)
+}
+
+func UnspecifiedPrimaryIpError(address string) error {
+	return fmt.Errorf("The first bind address is used by the cluster bus and can't be '%s'", address)
+}
+
+func IllegalSimulatedHostIpError(address string) error {
+	return fmt.Errorf("Simulated hosts require a single IPv4 loopback bind address but got '%s'", address)
+}
+
+func IllegalHostsCountError(hostsCount int) error {
+	return fmt.Errorf("Illegal hosts count %v", hostsCount)
+}
+
+func IllegalPersistenceModeError(mode string) error {
+	return fmt.Errorf("Illegal persistence mode '%s'. Should be one of %s", mode, strings.Join(persistenceModes, ", "))
+}
+
+func IllegalAppendFsyncError(policy string) error {
+	return fmt.Errorf("Illegal appendfsync policy '%s'. Should be one of %s", policy, strings.Join(appendFsyncPolicies, ", "))
+}
+
+func IllegalSavePointError(savePoint string) error {
+	return fmt.Errorf("Illegal save point '%s'. Should be in form '<seconds> <changes>'", savePoint)
+}
+
+func IllegalPasswordError() error {
+	return errors.New("Illegal password. It should not be empty or contain whitespaces, quotes or '>'")
+}
+
+func IllegalAclUserError(user string) error {
+	return fmt.Errorf("Illegal user '%s'. Should be in form name:password[:rules]", user)
+}
+
+func ReservedAclUserError(name string) error {
+	return fmt.Errorf("User '%s' is reserved. Use --password option to protect it", name)
+}
+
+type AclUser struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+	Rules    string `yaml:"rules,omitempty"`
+}
+
+// Parses user in form name:password[:rules] as it passed from command line
+func ParseAclUser(user string) (AclUser, error) {
+	parts := strings.SplitN(user, ":", 3)
+
+	if len(parts) < 2 {
+		return AclUser{}, IllegalAclUserError(user)
+	}
+
+	result := AclUser{Name: parts[0], Password: parts[1]}
+
+	if len(parts) > 2 {
+		result.Rules = strings.TrimSpace(parts[2])
+	}
+
+	if !userNameRegEx.MatchString(result.Name) || !secretRegEx.MatchString(result.Password) {
+		return AclUser{}, IllegalAclUserError(user)
+	}
+
+	if err := result.Validate(); err != nil {
+		return AclUser{}, err
+	}
+
+	return result, nil
+}
+
+func (self AclUser) Validate() error {
+	if !userNameRegEx.MatchString(self.Name) || !secretRegEx.MatchString(self.Password) {
+		return IllegalAclUserError(self.Name)
+	}
+
+	if self.Name == DefaultAclUser {
+		return ReservedAclUserError(self.Name)
+	}
+
+	return nil
+}
+
+func ValidatePassword(password string) error {
+	if !secretRegEx.MatchString(password) {
+		return IllegalPasswordError()
+	}
+
+	return nil
+}
+
+func (self AclUser) aclRules(defaultRules string) string {
+	if len(self.Rules) > 0 {
+		return self.Rules
+	}
+
+	return defaultRules
+}
+
+// Writes users in ACL file format. The default user is protected by password if it is specified. Channel rules are
+// known to Redis since 6.2 and are required since 7.0 where channels are denied by default
+func SaveAclFile(fileName string, password string, users []AclUser, channelRules bool) error {
+	defaultRules := DefaultAclRules
+
+	if channelRules {
+		defaultRules = AclChannelRules + " " + DefaultAclRules
+	}
+
+	var w *bufio.Writer
+
+	if f, err := os.Create(fileName); err != nil {
+		return err
+	} else {
+		w = bufio.NewWriter(f)
+		defer f.Close()
+	}
+
+	if len(password) > 0 {
+		if _, err := fmt.Fprintf(w, "user %s on >%s %s\n", DefaultAclUser, password, defaultRules); err != nil {
+			return err
+		}
+	} else {
+		if _, err := fmt.Fprintf(w, "user %s on nopass %s\n", DefaultAclUser, defaultRules); err != nil {
+			return err
+		}
+	}
+
+	for _, user := range users {
+		if _, err := fmt.Fprintf(w, "user %s on >%s %s\n", user.Name, user.Password, user.aclRules(defaultRules)); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+type PersistenceConf struct {
+	Mode        string   `yaml:"mode"`
+	Save        []string `yaml:"save,omitempty"`
+	AppendFsync string   `yaml:"appendfsync,omitempty"`
+}
+
+func NewPersistenceConf(mode string, save []string, appendFsync string) (PersistenceConf, error) {
+	result := PersistenceConf{
+		Mode:        strings.ToLower(strings.TrimSpace(mode)),
+		Save:        save,
+		AppendFsync: strings.ToLower(strings.TrimSpace(appendFsync)),
+	}
+
+	if err := result.Validate(); err != nil {
+		return PersistenceConf{}, err
+	}
+
+	return result, nil
+}
+
+// Supports the legacy boolean form of the persistence flag where true stands for AOF
+func (self *PersistenceConf) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+
+	if err := unmarshal(&enabled); err == nil {
+		if enabled {
+			self.Mode = PersistenceAof
+		} else {
+			self.Mode = PersistenceNone
+		}
+
+		return nil
+	}
+
+	type plain PersistenceConf
+	return unmarshal((*plain)(self))
+}
+
+func (self PersistenceConf) Validate() error {
+	if !contains(persistenceModes, self.mode()) {
+		return IllegalPersistenceModeError(self.Mode)
+	}
+
+	if len(self.AppendFsync) > 0 && !contains(appendFsyncPolicies, self.AppendFsync) {
+		return IllegalAppendFsyncError(self.AppendFsync)
+	}
+
+	for _, savePoint := range self.Save {
+		fields := strings.Fields(savePoint)
+
+		if len(fields) != 2 {
+			return IllegalSavePointError(savePoint)
+		}
+
+		for _, field := range fields {
+			if n, err := strconv.Atoi(field); err != nil || n < 1 {
+				return IllegalSavePointError(savePoint)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (self PersistenceConf) mode() string {
+	if len(self.Mode) < 1 {
+		return PersistenceNone
+	}
+
+	return self.Mode
+}
+
+func (self PersistenceConf) String() string {
+	switch {
+	case self.RdbEnabled() && self.AofEnabled():
+		return fmt.Sprintf("%s (save %s, appendfsync %s)", self.mode(), strings.Join(self.SavePoints(), ", "), self.Fsync())
+	case self.RdbEnabled():
+		return fmt.Sprintf("%s (save %s)", self.mode(), strings.Join(self.SavePoints(), ", "))
+	case self.AofEnabled():
+		return fmt.Sprintf("%s (appendfsync %s)", self.mode(), self.Fsync())
+	default:
+		return self.mode()
+	}
+}
+
+func (self PersistenceConf) RdbEnabled() bool {
+	return self.Mode == PersistenceRdb || self.Mode == PersistenceBoth
+}
+
+func (self PersistenceConf) AofEnabled() bool {
+	return self.Mode == PersistenceAof || self.Mode == PersistenceBoth
+}
+
+// Returns configured save points or the default ones when RDB is enabled. Empty when RDB is disabled
+func (self PersistenceConf) SavePoints() []string {
+	if !self.RdbEnabled() {
+		return []string{}
+	} else if len(self.Save) > 0 {
+		return self.Save
+	} else {
+		return DefaultRdbSaveSchedule
+	}
+}
+
+func (self PersistenceConf) Fsync() string {
+	if len(self.AppendFsync) > 0 {
+		return self.AppendFsync
+	}
+
+	return DefaultAppendFsync
+}
+
+// Bind addresses are stored space separated the same way they are written to redis.conf. The first one is used to
+// connect to the node and to announce it to the other nodes of the cluster. Binaries override the ones found in PATH
+// and distribution is the spec slots were last distributed with
 type ClusterConf struct {
-	ListenIp    string `yaml:"bind"`
-	ListenPorts []int  `yaml:"ports"`
-	Persistence bool
+	ListenIp       string                    `yaml:"bind"`
+	ListenPorts    []int                     `yaml:"ports"`
+	Persistence    PersistenceConf           `yaml:"persistence"`
+	Directives     map[string]string         `yaml:"directives,omitempty"`
+	Repeated       map[string][]string       `yaml:"repeated-directives,omitempty"`
+	NodeDirectives map[int]map[string]string `yaml:"node-directives,omitempty"`
+	Password       string                    `yaml:"password,omitempty"`
+	Users          []AclUser                 `yaml:"users,omitempty"`
+	Tls            bool                      `yaml:"tls,omitempty"`
+	UnixSocket     bool                      `yaml:"unixsocket,omitempty"`
+	NodeBind       map[int]string            `yaml:"node-bind,omitempty"`
+	Binaries       map[string]string         `yaml:"binaries,omitempty"`
+	Distribution   *SlotDistributionSpec     `yaml:"distribution,omitempty"`
+}
+
+func (self *ClusterConf) BindAddresses() []string {
+	return strings.Fields(self.ListenIp)
+}
+
+func (self *ClusterConf) PrimaryIp() string {
+	if addresses := self.BindAddresses(); len(addresses) > 0 {
+		return addresses[0]
+	}
+
+	return ""
+}
+
+// Returns bind addresses of the node listening on specified port. Node specific addresses are used to place nodes
+// on different (simulated) hosts
+func (self *ClusterConf) NodeListenIp(port int) string {
+	if listenIp, ok := self.NodeBind[port]; ok {
+		return listenIp
+	}
+
+	return self.ListenIp
+}
+
+func (self *ClusterConf) NodePrimaryIp(port int) string {
+	if addresses := strings.Fields(self.NodeListenIp(port)); len(addresses) > 0 {
+		return addresses[0]
+	}
+
+	return ""
+}
+
+func (self *ClusterConf) RemoveNode(port int) {
+	ports := make([]int, 0, len(self.ListenPorts))
+
+	for _, p := range self.ListenPorts {
+		if p != port {
+			ports = append(ports, p)
+		}
+	}
+
+	self.ListenPorts = ports
+	delete(self.NodeBind, port)
+	delete(self.NodeDirectives, port)
+
+	// The spec no longer describes the cluster once its nodes are removed
+	self.Distribution = nil
+}
+
+// Returns the copy of the configuration with nodes moved to new ports. Ports missing in the map are kept
+func (self *ClusterConf) WithPorts(portMap map[int]int) *ClusterConf {
+	result := *self
+	result.ListenPorts = make([]int, len(self.ListenPorts))
+
+	newPort := func(port int) int {
+		if p, ok := portMap[port]; ok {
+			return p
+		}
+
+		return port
+	}
+
+	for i, port := range self.ListenPorts {
+		result.ListenPorts[i] = newPort(port)
+	}
+
+	if self.NodeDirectives != nil {
+		result.NodeDirectives = make(map[int]map[string]string, len(self.NodeDirectives))
+
+		for port, directives := range self.NodeDirectives {
+			result.NodeDirectives[newPort(port)] = directives
+		}
+	}
+
+	if self.NodeBind != nil {
+		result.NodeBind = make(map[int]string, len(self.NodeBind))
+
+		for port, bind := range self.NodeBind {
+			result.NodeBind[newPort(port)] = bind
+		}
+	}
+
+	if self.Distribution != nil {
+		distribution := self.Distribution.withPorts(newPort)
+		result.Distribution = &distribution
+	}
+
+	return &result
+}
+
+// Returns the simulated host with the least number of nodes preferring hosts other than excluded one
+func (self *ClusterConf) LeastLoadedHost(exclude string) string {
+	counts := make(map[string]int)
+
+	for _, port := range self.ListenPorts {
+		if _, ok := self.NodeBind[port]; ok {
+			counts[self.NodePrimaryIp(port)] += 1
+		}
+	}
+
+	hosts := make([]string, 0, len(counts))
+
+	for host, _ := range counts {
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+
+	result := ""
+
+	for _, host := range hosts {
+		if len(result) < 1 || (result == exclude && host != exclude) ||
+			(host != exclude && counts[host] < counts[result]) {
+			result = host
+		}
+	}
+
+	return result
+}
+
+// Spreads nodes round robin across hosts with consecutive loopback addresses starting from the specified one
+func SimulatedHostsBind(baseIp string, ports []int, hostsCount int) (map[int]string, error) {
+	ip := net.ParseIP(baseIp).To4()
+
+	if ip == nil || !ip.IsLoopback() {
+		return nil, IllegalSimulatedHostIpError(baseIp)
+	}
+
+	if hostsCount < 1 || int(ip[3])+hostsCount-1 > 254 {
+		return nil, IllegalHostsCountError(hostsCount)
+	}
+
+	result := make(map[int]string, len(ports))
+
+	for i, port := range ports {
+		hostIp := net.IPv4(ip[0], ip[1], ip[2], ip[3]+byte(i%hostsCount))
+		result[port] = hostIp.String()
+	}
+
+	return result, nil
+}
+
+// Parses comma or space separated list of IPv4 or IPv6 addresses. IPv6 addresses can be enclosed in brackets
+func ParseBindAddresses(addresses string) ([]string, error) {
+	fields := strings.FieldsFunc(addresses, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+
+	if len(fields) < 1 {
+		return nil, BindAddressRequiredError
+	}
+
+	result := make([]string, len(fields))
+
+	for i, field := range fields {
+		host := strings.TrimSuffix(strings.TrimPrefix(field, "["), "]")
+		ip := net.ParseIP(host)
+
+		if ip == nil {
+			ip = resolveHost(host)
+		}
+
+		if ip == nil {
+			return nil, IllegalBindAddressError(field)
+		}
+
+		if contains(result, ip.String()) {
+			return nil, DuplicateBindAddressError(field)
+		}
+
+		result[i] = ip.String()
+	}
+
+	if net.ParseIP(result[0]).IsUnspecified() {
+		return nil, UnspecifiedPrimaryIpError(result[0])
+	}
+
+	return result, nil
+}
+
+// Resolves host name to its address preferring IPv4. Nodes are announced to the cluster bus by addresses only
+func resolveHost(host string) net.IP {
+	ips, err := net.LookupIP(host)
+
+	if err != nil || len(ips) < 1 {
+		return nil
+	}
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip
+		}
+	}
+
+	return ips[0]
+}
+
+// Returns directives of the node listening on specified port. Node specific directives override cluster wide ones
+func (self *ClusterConf) NodeRedisDirectives(port int) map[string]string {
+	result := make(map[string]string, len(self.Directives))
+
+	for name, value := range self.Directives {
+		result[name] = value
+	}
+
+	for name, value := range self.NodeDirectives[port] {
+		result[name] = value
+	}
+
+	return result
+}
+
+// Returns repeated directives of the node except the ones overridden by node specific directives
+func (self *ClusterConf) NodeRepeatedRedisDirectives(port int) map[string][]string {
+	result := make(map[string][]string, len(self.Repeated))
+
+	for name, values := range self.Repeated {
+		if _, ok := self.NodeDirectives[port][name]; !ok {
+			result[name] = values
+		}
+	}
+
+	return result
+}
+
+// Sets directive for every node of the cluster dropping node specific and repeated values of the directive
+func (self *ClusterConf) SetDirective(name string, value string) {
+	if self.Directives == nil {
+		self.Directives = make(map[string]string)
+	}
+
+	self.Directives[name] = value
+	delete(self.Repeated, name)
+
+	for port, directives := range self.NodeDirectives {
+		delete(directives, name)
+
+		if len(directives) < 1 {
+			delete(self.NodeDirectives, port)
+		}
+	}
+}
+
+func (self *ClusterConf) SetNodeDirective(port int, name string, value string) {
+	if self.NodeDirectives == nil {
+		self.NodeDirectives = make(map[int]map[string]string)
+	}
+
+	if self.NodeDirectives[port] == nil {
+		self.NodeDirectives[port] = make(map[string]string)
+	}
+
+	self.NodeDirectives[port][name] = value
 }
 
 func LoadClusterConf(fileName string) (*ClusterConf, error) {
@@ -36,13 +630,113 @@ func SaveClusterConf(fileName string, conf *ClusterConf) error {
 	}
 }
 
+func IsManagedRedisDirective(name string) bool {
+	return managedRedisDirectives[strings.ToLower(name)]
+}
+
+func IsRepeatableRedisDirective(name string) bool {
+	return repeatableRedisDirectives[strings.ToLower(name)]
+}
+
+func ValidateRedisDirectives(directives map[string]string) error {
+	for name, _ := range directives {
+		if IsManagedRedisDirective(name) {
+			return ManagedDirectiveError(name)
+		}
+	}
+
+	return nil
+}
+
+func ValidateRepeatedRedisDirectives(directives map[string][]string) error {
+	for name, _ := range directives {
+		if IsManagedRedisDirective(name) {
+			return ManagedDirectiveError(name)
+		}
+	}
+
+	return nil
+}
+
+// Parses directive in form key=value as it passed from command line
+func ParseRedisDirective(directive string) (string, string, error) {
+	parts := strings.SplitN(directive, "=", 2)
+
+	if len(parts) != 2 || len(strings.TrimSpace(parts[0])) < 1 {
+		return "", "", IllegalDirectiveError(directive)
+	}
+
+	return strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1]), nil
+}
+
+type RedisDirective struct {
+	Name  string
+	Value string
+}
+
+// Loads directives from the file in redis.conf format in order they occur. Directives can be repeated
+func LoadRedisDirectives(fileName string) ([]RedisDirective, error) {
+	data, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []RedisDirective{}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name := strings.Fields(line)[0]
+		value := strings.TrimSpace(line[len(name):])
+		result = append(result, RedisDirective{Name: strings.ToLower(name), Value: value})
+	}
+
+	return result, nil
+}
+
+// Splits directives into the ones which occur once and the repeated ones. Repeated values keep their order
+func GroupRedisDirectives(directives []RedisDirective) (map[string]string, map[string][]string) {
+	counts := make(map[string]int)
+
+	for _, directive := range directives {
+		counts[directive.Name] += 1
+	}
+
+	single := make(map[string]string)
+	repeated := make(map[string][]string)
+
+	for _, directive := range directives {
+		if counts[directive.Name] > 1 {
+			repeated[directive.Name] = append(repeated[directive.Name], directive.Value)
+		} else {
+			single[directive.Name] = directive.Value
+		}
+	}
+
+	return single, repeated
+}
+
 type RedisNodeConf struct {
 	ListenIp    string
 	ListenPort  int
-	Persistence bool
+	Persistence PersistenceConf
 	DataDir     string
 	PidFile     string
 	LogFile     string
+	Password    string
+	AclFile     string
+	Users       []AclUser
+	TlsCaFile   string
+	TlsCertFile string
+	TlsKeyFile  string
+	UnixSocket  string
+	Directives  map[string]string
+	Repeated    map[string][]string
 }
 
 func SaveRedisConf(fileName string, conf *RedisNodeConf) error {
@@ -64,8 +758,10 @@ func SaveRedisConf(fileName string, conf *RedisNodeConf) error {
 		return err
 	}
 
-	if _, err := w.WriteString("loglevel notice\n"); err != nil {
-		return err
+	if _, ok := conf.Directives["loglevel"]; !ok {
+		if _, err := fmt.Fprintf(w, "loglevel %s\n", DefaultRedisLogLevel); err != nil {
+			return err
+		}
 	}
 
 	if len(conf.ListenIp) > 0 {
@@ -74,12 +770,39 @@ func SaveRedisConf(fileName string, conf *RedisNodeConf) error {
 		}
 	}
 
-	if conf.ListenPort > 0 {
+	if conf.ListenPort > 0 && len(conf.TlsCertFile) > 0 {
+		if _, err := fmt.Fprintf(w, "port 0\ntls-port %d\n", conf.ListenPort); err != nil {
+			return err
+		}
+	} else if conf.ListenPort > 0 {
 		if _, err := fmt.Fprintf(w, "port %d\n", conf.ListenPort); err != nil {
 			return err
 		}
 	}
 
+	if len(conf.TlsCertFile) > 0 {
+		if _, err := fmt.Fprintf(
+			w,
+			"tls-cert-file %s\ntls-key-file %s\ntls-ca-cert-file %s\ntls-cluster yes\ntls-replication yes\n",
+			conf.TlsCertFile,
+			conf.TlsKeyFile,
+			conf.TlsCaFile); err != nil {
+			return err
+		}
+	}
+
+	if len(conf.UnixSocket) > 0 {
+		if _, err := fmt.Fprintf(w, "unixsocket %s\n", conf.UnixSocket); err != nil {
+			return err
+		}
+
+		if _, ok := conf.Directives["unixsocketperm"]; !ok {
+			if _, err := fmt.Fprintf(w, "unixsocketperm %s\n", DefaultUnixSocketPerm); err != nil {
+				return err
+			}
+		}
+	}
+
 	if len(conf.PidFile) > 0 {
 		if _, err := fmt.Fprintf(w, "pidfile %s\n", conf.PidFile); err != nil {
 			return err
@@ -96,19 +819,103 @@ func SaveRedisConf(fileName string, conf *RedisNodeConf) error {
 		return err
 	}
 
-	if conf.Persistence {
+	if conf.Persistence.RdbEnabled() {
+		for _, savePoint := range conf.Persistence.SavePoints() {
+			if _, err := fmt.Fprintf(w, "save %s\n", savePoint); err != nil {
+				return err
+			}
+		}
+	} else {
+		if _, err := w.WriteString("save \"\"\n"); err != nil {
+			return err
+		}
+	}
+
+	if conf.Persistence.AofEnabled() {
 		if _, err := w.WriteString("appendonly yes\n"); err != nil {
 			return err
 		}
+
+		if _, err := fmt.Fprintf(w, "appendfsync %s\n", conf.Persistence.Fsync()); err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "aof-use-rdb-preamble %s\n", yesNo(conf.Persistence.RdbEnabled())); err != nil {
+			return err
+		}
 	} else {
 		if _, err := w.WriteString("appendonly no\n"); err != nil {
 			return err
 		}
+	}
 
-		if _, err := w.WriteString("save \"\"\n"); err != nil {
+	if len(conf.AclFile) > 0 {
+		if _, err := fmt.Fprintf(w, "aclfile %s\n", conf.AclFile); err != nil {
+			return err
+		}
+	} else if len(conf.Password) > 0 {
+		if _, err := fmt.Fprintf(w, "requirepass %s\n", conf.Password); err != nil {
 			return err
 		}
 	}
 
+	if len(conf.Password) > 0 {
+		if _, err := fmt.Fprintf(w, "masterauth %s\n", conf.Password); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(conf.Directives))
+
+	for name, _ := range conf.Directives {
+		if !IsManagedRedisDirective(name) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%s %s\n", name, conf.Directives[name]); err != nil {
+			return err
+		}
+	}
+
+	names = make([]string, 0, len(conf.Repeated))
+
+	for name, _ := range conf.Repeated {
+		if _, ok := conf.Directives[name]; !ok && !IsManagedRedisDirective(name) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range conf.Repeated[name] {
+			if _, err := fmt.Fprintf(w, "%s %s\n", name, value); err != nil {
+				return err
+			}
+		}
+	}
+
 	return w.Flush()
 }
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
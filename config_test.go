@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -18,12 +19,12 @@ func TestSaveLoadClusterConf(t *testing.T) {
 		ClusterConf{
 			ListenIp:    "127.0.0.1",
 			ListenPorts: []int{7501, 7502, 7503, 7504, 7505, 7506},
-			Persistence: true,
+			Persistence: PersistenceConf{Mode: PersistenceAof},
 		},
 		ClusterConf{
 			ListenIp:    "localhost",
 			ListenPorts: []int{7501, 7502},
-			Persistence: false,
+			Persistence: PersistenceConf{Mode: PersistenceNone},
 		},
 	}
 
@@ -62,13 +63,20 @@ func TestSaveNodeConf(t *testing.T) {
 		RedisNodeConf{
 			ListenIp:    "127.0.0.1",
 			ListenPort:  6379,
-			Persistence: true,
+			Persistence: PersistenceConf{Mode: PersistenceAof},
 			DataDir:     "/tmp",
 			LogFile:     "/tmp/redis.log",
 		},
 		RedisNodeConf{
+			ListenIp:    "::1 127.0.0.1",
 			ListenPort:  6379,
-			Persistence: true,
+			Persistence: PersistenceConf{Mode: PersistenceAof},
+			DataDir:     "/tmp",
+			LogFile:     "/tmp/redis.log",
+		},
+		RedisNodeConf{
+			ListenPort:  6379,
+			Persistence: PersistenceConf{Mode: PersistenceAof},
 			DataDir:     "/tmp",
 			LogFile:     "/tmp/redis.log",
 		},
@@ -137,6 +145,672 @@ func TestSaveNodeConf(t *testing.T) {
 	}
 }
 
+func TestSaveNodeConfDirectives(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_conf_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	conf := RedisNodeConf{
+		ListenPort: 6379,
+		DataDir:    "/tmp",
+		Directives: map[string]string{
+			"cluster-node-timeout": "5000",
+			"maxmemory":            "100mb",
+			"loglevel":             "debug",
+			"port":                 "7000",
+		},
+	}
+
+	fname := tmpdir + "/" + randStringRunes(6) + ".conf"
+
+	if err := SaveRedisConf(fname, &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(fname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataStr := string(data)
+
+	for _, expected := range []string{"cluster-node-timeout 5000\n", "maxmemory 100mb\n", "loglevel debug\n", "port 6379\n"} {
+		if !strings.Contains(dataStr, expected) {
+			t.Errorf("Expected '%s' to be present in the redis.conf file", strings.TrimSpace(expected))
+		}
+	}
+
+	for _, unexpected := range []string{"loglevel notice\n", "port 7000\n"} {
+		if strings.Contains(dataStr, unexpected) {
+			t.Errorf("'%s' record SHOULD NOT be present in the redis.conf file", strings.TrimSpace(unexpected))
+		}
+	}
+}
+
+func TestParseRedisDirective(t *testing.T) {
+	cases := []struct {
+		directive string
+		name      string
+		value     string
+		isValid   bool
+	}{
+		{"maxmemory=100mb", "maxmemory", "100mb", true},
+		{"Notify-Keyspace-Events = KEA", "notify-keyspace-events", "KEA", true},
+		{"client-output-buffer-limit=pubsub 32mb 8mb 60", "client-output-buffer-limit", "pubsub 32mb 8mb 60", true},
+		{"maxmemory", "", "", false},
+		{"=100mb", "", "", false},
+	}
+
+	for _, c := range cases {
+		name, value, err := ParseRedisDirective(c.directive)
+
+		if !c.isValid {
+			if err == nil {
+				t.Errorf("Expected '%s' to be rejected", c.directive)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %v", c.directive, err)
+		} else if name != c.name || value != c.value {
+			t.Errorf("Expected %s=%s but got %s=%s", c.name, c.value, name, value)
+		}
+	}
+}
+
+func TestValidateRedisDirectives(t *testing.T) {
+	if err := ValidateRedisDirectives(map[string]string{"maxmemory": "100mb"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := ValidateRedisDirectives(map[string]string{"maxmemory": "100mb", "Dir": "/tmp"}); err == nil {
+		t.Errorf("Expected managed directive to be rejected")
+	}
+}
+
+func TestLoadRedisDirectives(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_conf_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	fname := tmpdir + "/base.conf"
+	content := "# comment\nmaxmemory\t100mb\nrename-command FLUSHALL \"\"\n" +
+		"Rename-Command  CONFIG   MYCONFIG\nport 7000\n"
+
+	if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	directives, err := LoadRedisDirectives(fname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []RedisDirective{
+		{"maxmemory", "100mb"},
+		{"rename-command", "FLUSHALL \"\""},
+		{"rename-command", "CONFIG   MYCONFIG"},
+		{"port", "7000"},
+	}
+
+	if !reflect.DeepEqual(directives, expected) {
+		t.Errorf("Expected %v but got %v", expected, directives)
+	}
+
+	single, repeated := GroupRedisDirectives(directives)
+
+	if single["maxmemory"] != "100mb" || single["port"] != "7000" || len(single) != 2 {
+		t.Errorf("Unexpected single directives %v", single)
+	}
+
+	if !reflect.DeepEqual(repeated, map[string][]string{"rename-command": {"FLUSHALL \"\"", "CONFIG   MYCONFIG"}}) {
+		t.Errorf("Unexpected repeated directives %v", repeated)
+	}
+}
+
+func TestSaveNodeConfRepeatedDirectives(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_conf_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	conf := &ClusterConf{
+		ListenIp:       "127.0.0.1",
+		ListenPorts:    []int{7501, 7502},
+		Repeated:       map[string][]string{"rename-command": {"FLUSHALL X", "CONFIG Y"}},
+		NodeDirectives: map[int]map[string]string{7502: {"rename-command": "DEBUG Z"}},
+	}
+
+	fname := tmpdir + "/redis.conf"
+	nodeConf := RedisNodeConf{ListenPort: 7501, DataDir: "/tmp", Repeated: conf.NodeRepeatedRedisDirectives(7501)}
+
+	if err := SaveRedisConf(fname, &nodeConf); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := ioutil.ReadFile(fname); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(data), "rename-command FLUSHALL X\nrename-command CONFIG Y\n") {
+		t.Errorf("Expected repeated directives to be written in order but got\n%s", data)
+	}
+
+	if repeated := conf.NodeRepeatedRedisDirectives(7502); len(repeated) > 0 {
+		t.Errorf("Expected node directive to override repeated one but got %v", repeated)
+	}
+
+	conf.SetDirective("rename-command", "FLUSHALL X")
+
+	if len(conf.Repeated) > 0 {
+		t.Errorf("Expected repeated values to be replaced but got %v", conf.Repeated)
+	}
+}
+
+func TestClusterConfNodeDirectives(t *testing.T) {
+	conf := ClusterConf{
+		ListenPorts: []int{7501, 7502},
+		Directives:  map[string]string{"maxmemory": "100mb"},
+	}
+
+	conf.SetNodeDirective(7501, "maxmemory", "200mb")
+	conf.SetNodeDirective(7501, "cluster-node-timeout", "5000")
+
+	if value := conf.NodeRedisDirectives(7501)["maxmemory"]; value != "200mb" {
+		t.Errorf("Expected %v but got %v", "200mb", value)
+	}
+
+	if value := conf.NodeRedisDirectives(7502)["maxmemory"]; value != "100mb" {
+		t.Errorf("Expected %v but got %v", "100mb", value)
+	}
+
+	conf.SetDirective("maxmemory", "300mb")
+
+	for _, port := range conf.ListenPorts {
+		if value := conf.NodeRedisDirectives(port)["maxmemory"]; value != "300mb" {
+			t.Errorf("Expected %v but got %v for node %v", "300mb", value, port)
+		}
+	}
+
+	if value := conf.NodeRedisDirectives(7501)["cluster-node-timeout"]; value != "5000" {
+		t.Errorf("Expected %v but got %v", "5000", value)
+	}
+}
+
+func TestLoadLegacyPersistenceFlag(t *testing.T) {
+	cases := map[string]string{
+		"bind: 127.0.0.1\nports: [7501, 7502]\npersistence: true\n":  PersistenceAof,
+		"bind: 127.0.0.1\nports: [7501, 7502]\npersistence: false\n": PersistenceNone,
+		"bind: 127.0.0.1\nports: [7501, 7502]\n":                     "",
+	}
+
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_conf_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	for data, expectedMode := range cases {
+		fname := tmpdir + "/" + randStringRunes(6) + ".yml"
+
+		if err := ioutil.WriteFile(fname, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		conf, err := LoadClusterConf(fname)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if conf.Persistence.Mode != expectedMode {
+			t.Errorf("Expected %v but got %v", expectedMode, conf.Persistence.Mode)
+		}
+	}
+}
+
+func TestSaveNodeConfPersistence(t *testing.T) {
+	cases := []struct {
+		persistence PersistenceConf
+		expected    []string
+		unexpected  []string
+	}{
+		{
+			PersistenceConf{Mode: PersistenceNone},
+			[]string{"save \"\"\n", "appendonly no\n"},
+			[]string{"appendonly yes\n", "appendfsync"},
+		},
+		{
+			PersistenceConf{Mode: PersistenceRdb, Save: []string{"60 1000"}},
+			[]string{"save 60 1000\n", "appendonly no\n"},
+			[]string{"save \"\"\n", "appendonly yes\n"},
+		},
+		{
+			PersistenceConf{Mode: PersistenceAof, AppendFsync: "always"},
+			[]string{"save \"\"\n", "appendonly yes\n", "appendfsync always\n", "aof-use-rdb-preamble no\n"},
+			[]string{"appendonly no\n"},
+		},
+		{
+			PersistenceConf{Mode: PersistenceBoth},
+			[]string{"save 3600 1\n", "appendonly yes\n", "appendfsync everysec\n", "aof-use-rdb-preamble yes\n"},
+			[]string{"save \"\"\n", "appendonly no\n"},
+		},
+	}
+
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_conf_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	for _, c := range cases {
+		fname := tmpdir + "/" + randStringRunes(6) + ".conf"
+
+		if err := SaveRedisConf(fname, &RedisNodeConf{ListenPort: 6379, DataDir: "/tmp", Persistence: c.persistence}); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(fname)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dataStr := string(data)
+
+		for _, expected := range c.expected {
+			if !strings.Contains(dataStr, expected) {
+				t.Errorf("Expected '%s' to be present in the redis.conf file for %s mode", strings.TrimSpace(expected), c.persistence.Mode)
+			}
+		}
+
+		for _, unexpected := range c.unexpected {
+			if strings.Contains(dataStr, unexpected) {
+				t.Errorf("'%s' record SHOULD NOT be present in the redis.conf file for %s mode", strings.TrimSpace(unexpected), c.persistence.Mode)
+			}
+		}
+	}
+}
+
+func TestNewPersistenceConf(t *testing.T) {
+	if _, err := NewPersistenceConf("rdb", []string{"900 1", "300 10"}, ""); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if _, err := NewPersistenceConf("AOF", nil, "always"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if _, err := NewPersistenceConf("sometimes", nil, ""); err == nil {
+		t.Errorf("Expected illegal mode to be rejected")
+	}
+
+	if _, err := NewPersistenceConf("aof", nil, "never"); err == nil {
+		t.Errorf("Expected illegal appendfsync policy to be rejected")
+	}
+
+	if _, err := NewPersistenceConf("rdb", []string{"900"}, ""); err == nil {
+		t.Errorf("Expected illegal save point to be rejected")
+	}
+}
+
+func TestSaveNodeConfAuth(t *testing.T) {
+	cases := []struct {
+		conf       RedisNodeConf
+		expected   []string
+		unexpected []string
+	}{
+		{
+			RedisNodeConf{ListenPort: 6379, DataDir: "/tmp", Password: "secret"},
+			[]string{"requirepass secret\n", "masterauth secret\n"},
+			[]string{"aclfile"},
+		},
+		{
+			RedisNodeConf{ListenPort: 6379, DataDir: "/tmp", Password: "secret", AclFile: "/tmp/users.acl"},
+			[]string{"aclfile /tmp/users.acl\n", "masterauth secret\n"},
+			[]string{"requirepass"},
+		},
+		{
+			RedisNodeConf{ListenPort: 6379, DataDir: "/tmp"},
+			[]string{},
+			[]string{"requirepass", "masterauth", "aclfile"},
+		},
+	}
+
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_conf_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	for _, c := range cases {
+		fname := tmpdir + "/" + randStringRunes(6) + ".conf"
+
+		if err := SaveRedisConf(fname, &c.conf); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(fname)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dataStr := string(data)
+
+		for _, expected := range c.expected {
+			if !strings.Contains(dataStr, expected) {
+				t.Errorf("Expected '%s' to be present in the redis.conf file", strings.TrimSpace(expected))
+			}
+		}
+
+		for _, unexpected := range c.unexpected {
+			if strings.Contains(dataStr, unexpected) {
+				t.Errorf("'%s' record SHOULD NOT be present in the redis.conf file", strings.TrimSpace(unexpected))
+			}
+		}
+	}
+}
+
+func TestSaveNodeConfTls(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_conf_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	conf := RedisNodeConf{
+		ListenPort:  6379,
+		DataDir:     "/tmp",
+		TlsCaFile:   "/tmp/ca.crt",
+		TlsCertFile: "/tmp/redis.crt",
+		TlsKeyFile:  "/tmp/redis.key",
+	}
+
+	fname := tmpdir + "/" + randStringRunes(6) + ".conf"
+
+	if err := SaveRedisConf(fname, &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(fname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataStr := string(data)
+
+	expectedRecords := []string{
+		"\nport 0\n",
+		"\ntls-port 6379\n",
+		"\ntls-cert-file /tmp/redis.crt\n",
+		"\ntls-key-file /tmp/redis.key\n",
+		"\ntls-ca-cert-file /tmp/ca.crt\n",
+		"\ntls-cluster yes\n",
+		"\ntls-replication yes\n",
+	}
+
+	for _, expected := range expectedRecords {
+		if !strings.Contains(dataStr, expected) {
+			t.Errorf("Expected '%s' to be present in the redis.conf file", strings.TrimSpace(expected))
+		}
+	}
+}
+
+func TestSaveNodeConfUnixSocket(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_conf_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	cases := []struct {
+		conf     RedisNodeConf
+		expected []string
+	}{
+		{
+			RedisNodeConf{ListenPort: 6379, DataDir: "/tmp", UnixSocket: "/tmp/redis.sock"},
+			[]string{"unixsocket /tmp/redis.sock\n", "unixsocketperm 700\n"},
+		},
+		{
+			RedisNodeConf{
+				ListenPort: 6379,
+				DataDir:    "/tmp",
+				UnixSocket: "/tmp/redis.sock",
+				Directives: map[string]string{"unixsocketperm": "770"},
+			},
+			[]string{"unixsocket /tmp/redis.sock\n", "unixsocketperm 770\n"},
+		},
+	}
+
+	for _, c := range cases {
+		fname := tmpdir + "/" + randStringRunes(6) + ".conf"
+
+		if err := SaveRedisConf(fname, &c.conf); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(fname)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dataStr := string(data)
+
+		for _, expected := range c.expected {
+			if !strings.Contains(dataStr, expected) {
+				t.Errorf("Expected '%s' to be present in the redis.conf file", strings.TrimSpace(expected))
+			}
+		}
+
+		if strings.Count(dataStr, "unixsocketperm") != 1 {
+			t.Errorf("Expected exactly one 'unixsocketperm' record in the redis.conf file")
+		}
+	}
+}
+
+func TestSaveAclFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_cluster_conf_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	fname := tmpdir + "/" + randStringRunes(6) + ".acl"
+	users := []AclUser{
+		AclUser{Name: "app", Password: "app-secret"},
+		AclUser{Name: "reader", Password: "reader-secret", Rules: "~* +@read"},
+	}
+
+	cases := []struct {
+		channelRules bool
+		expected     string
+	}{
+		{
+			false,
+			"user default on >secret ~* +@all\n" +
+				"user app on >app-secret ~* +@all\n" +
+				"user reader on >reader-secret ~* +@read\n",
+		},
+		{
+			true,
+			"user default on >secret &* ~* +@all\n" +
+				"user app on >app-secret &* ~* +@all\n" +
+				"user reader on >reader-secret ~* +@read\n",
+		},
+	}
+
+	for _, c := range cases {
+		if err := SaveAclFile(fname, "secret", users, c.channelRules); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(fname)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != c.expected {
+			t.Errorf("Expected %q but got %q", c.expected, string(data))
+		}
+	}
+}
+
+func TestAclChannelsSupported(t *testing.T) {
+	cases := []struct {
+		output   string
+		expected bool
+	}{
+		{"Redis server v=6.0.16 sha=00000000:0 malloc=jemalloc-5.1.0 bits=64 build=6d95e1af3a2c082a", false},
+		{"Redis server v=6.2.0 sha=00000000:0 malloc=libc bits=64 build=1b4b6c1d2e3f4a5b", true},
+		{"Redis server v=7.2.4 sha=00000000:0 malloc=jemalloc-5.3.0 bits=64 build=7e1c9b2f3d4a5c6e", true},
+		{"Redis server v=10.0.1 sha=00000000:0", true},
+		{"unexpected", false},
+	}
+
+	for _, c := range cases {
+		if result := aclChannelsSupported(c.output); result != c.expected {
+			t.Errorf("Expected %v for %q but got %v", c.expected, c.output, result)
+		}
+	}
+}
+
+func TestParseAclUser(t *testing.T) {
+	cases := []struct {
+		user     string
+		expected AclUser
+		isValid  bool
+	}{
+		{"app:secret", AclUser{Name: "app", Password: "secret"}, true},
+		{"reader:secret:~cache:* +get", AclUser{Name: "reader", Password: "secret", Rules: "~cache:* +get"}, true},
+		{"app", AclUser{}, false},
+		{"app:", AclUser{}, false},
+		{"app:two words", AclUser{}, false},
+		{"default:secret", AclUser{}, false},
+	}
+
+	for _, c := range cases {
+		user, err := ParseAclUser(c.user)
+
+		if !c.isValid {
+			if err == nil {
+				t.Errorf("Expected '%s' to be rejected", c.user)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %v", c.user, err)
+		} else if user != c.expected {
+			t.Errorf("Expected %v but got %v", c.expected, user)
+		}
+	}
+}
+
+func TestParseBindAddresses(t *testing.T) {
+	cases := []struct {
+		addresses string
+		expected  []string
+		isValid   bool
+	}{
+		{"127.0.0.1", []string{"127.0.0.1"}, true},
+		{"127.0.0.1,::1", []string{"127.0.0.1", "::1"}, true},
+		{"[::1], 127.0.0.1", []string{"::1", "127.0.0.1"}, true},
+		{"0:0:0:0:0:0:0:1", []string{"::1"}, true},
+		{"127.0.0.1,0.0.0.0", []string{"127.0.0.1", "0.0.0.0"}, true},
+		{"", nil, false},
+		{"localhost", []string{"127.0.0.1"}, true},
+		{"localhost,::1", []string{"127.0.0.1", "::1"}, true},
+		{"no-such-host.invalid", nil, false},
+		{"127.0.0.1,127.0.0.1", nil, false},
+		{"::", nil, false},
+	}
+
+	for _, c := range cases {
+		addresses, err := ParseBindAddresses(c.addresses)
+
+		if !c.isValid {
+			if err == nil {
+				t.Errorf("Expected '%s' to be rejected", c.addresses)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %v", c.addresses, err)
+		} else if !reflect.DeepEqual(addresses, c.expected) {
+			t.Errorf("Expected %v but got %v", c.expected, addresses)
+		}
+	}
+}
+
+func TestSimulatedHostsBind(t *testing.T) {
+	bind, err := SimulatedHostsBind("127.0.0.1", []int{9001, 9002, 9003, 9004}, 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[int]string{9001: "127.0.0.1", 9002: "127.0.0.2", 9003: "127.0.0.3", 9004: "127.0.0.1"}
+
+	if !reflect.DeepEqual(bind, expected) {
+		t.Errorf("Expected %v but got %v", expected, bind)
+	}
+
+	if _, err := SimulatedHostsBind("::1", []int{9001, 9002}, 2); err == nil {
+		t.Errorf("Expected IPv6 address to be rejected")
+	}
+
+	if _, err := SimulatedHostsBind("192.168.0.1", []int{9001, 9002}, 2); err == nil {
+		t.Errorf("Expected non loopback address to be rejected")
+	}
+}
+
+func TestLeastLoadedHost(t *testing.T) {
+	ports := []int{9001, 9002, 9003, 9004}
+	conf := ClusterConf{ListenIp: "127.0.0.1", ListenPorts: ports}
+	conf.NodeBind, _ = SimulatedHostsBind(conf.ListenIp, ports, 3)
+
+	if host := conf.LeastLoadedHost(""); host != "127.0.0.2" {
+		t.Errorf("Expected %v but got %v", "127.0.0.2", host)
+	}
+
+	if host := conf.LeastLoadedHost("127.0.0.2"); host != "127.0.0.3" {
+		t.Errorf("Expected %v but got %v", "127.0.0.3", host)
+	}
+
+	if host := (&ClusterConf{ListenPorts: ports}).LeastLoadedHost(""); host != "" {
+		t.Errorf("Expected no host but got %v", host)
+	}
+}
+
 // Supporting code
 
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -154,7 +828,7 @@ func randStringRunes(n int) string {
 }
 
 var redisConfRegExps = map[string]*regexp.Regexp{
-	"bind": regexp.MustCompile(`bind\s*([0-9\w\._-]+)\s*\n`),
+	"bind": regexp.MustCompile(`bind\s*([0-9\w\._:-]+(?: [0-9\w\._:-]+)*)\s*\n`),
 	"port": regexp.MustCompile(`port\s*([0-9]+)\s*\n`),
 	"dir":  regexp.MustCompile(`dir\s*([0-9\w/\._-]+)\s*\n`),
 }
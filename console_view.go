@@ -33,3 +33,13 @@ func (self *ConsoleView) Success(format string, args ...interface{}) {
 
 	fmt.Printf("%s %s\n", green("SUCCESS"), message)
 }
+
+func (self *ConsoleView) Progress(current int, total int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	fmt.Printf("\r\033[K[%v/%v] %s", current, total, message)
+
+	if current >= total {
+		fmt.Println()
+	}
+}
@@ -21,6 +21,8 @@ const (
 	MinTcpPort                  = 1
 	MaxTcpPort                  = 65535
 	RedisGossipPortIncrement    = 10000
+	RoleMaster                  = "master"
+	RoleSlave                   = "slave"
 )
 
 var (
@@ -33,6 +35,20 @@ var (
 	CountDescriptionRequiredError = errors.New("Nodes count is required")
 	IllegalPercentValueError      = errors.New("Illegal percent value. Should be in rage 0..100")
 	ClusterIsDownError            = errors.New("All cluster nodes are down")
+	DirectiveRequiredError        = errors.New("Name of the directive is required")
+	NoNodesSelectedError          = errors.New("No nodes match specified criteria")
+	TlsNotEnabledError            = errors.New("TLS is not enabled for the cluster")
+	IllegalAddNodeCountError      = errors.New("Number of nodes to add should be at least 1")
+	ConflictingRoleOptionsError   = errors.New("Options --replica-of and --master can't be used together")
+	NoFreePortsError              = errors.New("There are no free ports left")
+	SameSourceAndTargetError      = errors.New("Source and target nodes should be different")
+	SlotsRequiredError            = errors.New("Either number of slots or slot ranges should be specified")
+	FailoverTargetRequiredError   = errors.New("Either replica port, --of <master port> or --random should be specified")
+	ConflictingFailoverModesError = errors.New("Options --force and --takeover can't be used together")
+	NoReplicasAvailableError      = errors.New("There are no masters with available replicas")
+	MasterPortRequiredError       = errors.New("Port of the master should be specified with --of option")
+	InterruptedDistributionError  = errors.New("Distribution of slots was interrupted. Use --resume to complete it")
+	NothingToResumeError          = errors.New("There is no interrupted distribution of slots to resume")
 )
 
 func ClusterExistsError(clusterName string) error {
@@ -59,15 +75,87 @@ func IllegalNodeCount(availableNodeCount int) error {
 	return fmt.Errorf("Node count should be in range 1..%v (up nodes)", availableNodeCount)
 }
 
+func NodeDoesNotExistError(port int) error {
+	return fmt.Errorf("Node with port %v does not exist", port)
+}
+
+func SlotsWouldBeUncoveredError(slotCount int) error {
+	return fmt.Errorf("Removing the node would leave %v slots uncovered. Use --force to remove it anyway", slotCount)
+}
+
+func SlotIsNotOwnedError(slot int, address NodeAddress) error {
+	return fmt.Errorf("Slot %v is not served by %s", slot, address)
+}
+
+func NotEnoughSlotsError(available int, address NodeAddress) error {
+	return fmt.Errorf("Node %s serves only %v slots", address, available)
+}
+
+func NodeIsNotSlaveError(address NodeAddress) error {
+	return fmt.Errorf("Node %s is not a replica", address)
+}
+
+func MasterHasNoReplicasError(address NodeAddress) error {
+	return fmt.Errorf("Master %s has no available replicas", address)
+}
+
+func NodeServesSlotsError(address NodeAddress, slotCount int) error {
+	return fmt.Errorf("Node %s is a master serving %v slots and can't become a replica", address, slotCount)
+}
+
+func MasterWouldBeOrphanedError(address NodeAddress) error {
+	return fmt.Errorf("Master %s would be left without replicas. Use --force to proceed anyway", address)
+}
+
+func ClusterIsRunningError(clusterName string) error {
+	return fmt.Errorf("Cluster %s is running. Stop it first", clusterName)
+}
+
+func PortIsUsedError(port int) error {
+	return fmt.Errorf("Port %v is already used by another cluster", port)
+}
+
+func NodeIsNotMasterError(address NodeAddress) error {
+	return fmt.Errorf("Node %s is not a master", address)
+}
+
+func IllegalRoleError(role string) error {
+	return fmt.Errorf("Illegal role '%s'. Should be one of %s, %s", role, RoleMaster, RoleSlave)
+}
+
 type CreateProperties struct {
 	nodesCount                int
 	listenIp                  string
 	startPort                 int
-	persistence               bool
+	persistenceMode           string
+	savePoints                []string
+	appendFsync               string
+	confFile                  string
+	confDirectives            []string
+	password                  string
+	users                     []string
+	tls                       bool
+	unixSocket                bool
+	hostsCount                int
 	performFinalConfiguration bool
 	sayYes                    bool
 }
 
+type DistributionProperties struct {
+	replicas       int
+	replicasSet    bool
+	specFile       string
+	masters        []string
+	emptyMasters   []string
+	replicaCounts  []string
+	weights        []string
+	noAntiAffinity bool
+	planIn         string
+	planOut        string
+	resume         bool
+	sayYes         bool
+}
+
 func NewController(view View, clusterSet *ClusterSet) *Controller {
 	return &Controller{
 		view:       view,
@@ -110,11 +198,59 @@ func (self *Controller) Create(clusterName string, props CreateProperties) error
 		ports[i] = props.startPort + i
 	}
 
-	if self.view.Ask(
-		"Create clustrer %s with %v nodes listening on %v:%v?",
+	bindAddresses, err := ParseBindAddresses(props.listenIp)
+
+	if err != nil {
+		return err
+	}
+
+	var nodeBind map[int]string
+
+	if props.hostsCount > 1 {
+		if len(bindAddresses) > 1 {
+			return IllegalSimulatedHostIpError(strings.Join(bindAddresses, ","))
+		}
+
+		if nodeBind, err = SimulatedHostsBind(bindAddresses[0], ports, props.hostsCount); err != nil {
+			return err
+		}
+	}
+
+	directives, repeated, skipped, err := collectRedisDirectives(props.confFile, props.confDirectives)
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range skipped {
+		self.view.Echo("%s Directive %s of %s is managed by rcm and is skipped", yellow("WARNING"), bold(name), props.confFile)
+	}
+
+	persistence, err := NewPersistenceConf(props.persistenceMode, props.savePoints, props.appendFsync)
+
+	if err != nil {
+		return err
+	}
+
+	if len(props.password) > 0 {
+		if err := ValidatePassword(props.password); err != nil {
+			return err
+		}
+	}
+
+	users := make([]AclUser, len(props.users))
+
+	for i, user := range props.users {
+		if users[i], err = ParseAclUser(user); err != nil {
+			return err
+		}
+	}
+
+	if props.sayYes || self.view.Ask(
+		"Create clustrer %s with %v nodes listening on %v, ports %v?",
 		bold(clusterName),
 		props.nodesCount,
-		props.listenIp,
+		strings.Join(bindAddresses, ","),
 		ports) {
 
 		self.view.Echo("Creating cluster %s...", bold(clusterName))
@@ -122,9 +258,16 @@ func (self *Controller) Create(clusterName string, props CreateProperties) error
 		_, err := self.clusterSet.Create(
 			clusterName,
 			&ClusterConf{
-				ListenIp:    props.listenIp,
+				ListenIp:    strings.Join(bindAddresses, " "),
 				ListenPorts: ports,
-				Persistence: props.persistence,
+				Persistence: persistence,
+				Directives:  directives,
+				Repeated:    repeated,
+				Password:    props.password,
+				Users:       users,
+				Tls:         props.tls,
+				UnixSocket:  props.unixSocket,
+				NodeBind:    nodeBind,
 			})
 
 		if err != nil {
@@ -141,6 +284,174 @@ func (self *Controller) Create(clusterName string, props CreateProperties) error
 	return nil
 }
 
+// Creates the cluster described by the spec file, starts it and distributes slots. The name given explicitly wins over
+// the one from the spec
+func (self *Controller) CreateFromSpec(clusterName string, specFile string, sayYes bool) error {
+	spec, err := LoadClusterSpec(specFile)
+
+	if err != nil {
+		return err
+	}
+
+	if len(clusterName) < 1 {
+		clusterName = spec.Name
+	}
+
+	if len(clusterName) < MinClusterNameLength {
+		return ClusterNameRequiredError
+	}
+
+	if !clusterNameRegEx.MatchString(clusterName) {
+		return IllegalClusterNameError
+	}
+
+	if self.clusterSet.Exists(clusterName) {
+		return ClusterExistsError(clusterName)
+	}
+
+	conf, err := spec.ClusterConf()
+
+	if err != nil {
+		return err
+	}
+
+	maxPort := MaxTcpPort - RedisGossipPortIncrement
+
+	if len(conf.ListenPorts) < MinNodesCount {
+		return TooFewNumberOfNodesError()
+	}
+
+	for _, port := range conf.ListenPorts {
+		if port < MinTcpPort || port > maxPort {
+			return PortOutOfRangeError(maxPort)
+		}
+	}
+
+	if !sayYes && !self.view.Ask(
+		"Create cluster %s with %v nodes listening on %v, ports %v, start it and distribute slots?",
+		bold(clusterName),
+		len(conf.ListenPorts),
+		strings.Replace(conf.ListenIp, " ", ",", -1),
+		conf.ListenPorts) {
+
+		self.view.Aborted()
+		return nil
+	}
+
+	self.view.Echo("Creating cluster %s...", bold(clusterName))
+
+	cluster, err := self.clusterSet.Create(clusterName, conf)
+
+	if err != nil {
+		return err
+	}
+
+	self.view.Echo("Starting %v nodes...", cluster.NodesCount())
+
+	if err := cluster.StartAndWait(); err != nil {
+		return err
+	}
+
+	shards, err := cluster.PrepareSlotDistribution(*conf.Distribution)
+
+	if err != nil {
+		return err
+	}
+
+	self.echoShards(shards)
+	return self.distribute(clusterName, cluster, shards)
+}
+
+// Prints the spec the cluster can be recreated from. Distribution of the running cluster is taken from its topology
+func (self *Controller) Export(clusterName string) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	conf := *cluster.Conf()
+
+	if nodes, splitIndex, err := cluster.NodesByState(); err != nil {
+		return err
+	} else if splitIndex > 0 {
+		antiAffinity := conf.Distribution == nil || conf.Distribution.AntiAffinity
+
+		if topology, err := nodes[0].ClusterTopology(); err != nil {
+			return err
+		} else if distribution, ok := DistributionSpecOf(topology, antiAffinity); ok {
+			conf.Distribution = &distribution
+		}
+	}
+
+	spec, err := NewClusterSpec(clusterName, &conf).Marshal()
+
+	if err != nil {
+		return err
+	}
+
+	self.view.Echo("%s", strings.TrimSuffix(spec, "\n"))
+	return nil
+}
+
+// Merges directives from the base config file with the ones specified explicitly. Explicit directives replace every
+// occurrence of the directive in the file except repeatable ones which are added after. Managed directives of the file are skipped and their names are returned
+func collectRedisDirectives(confFile string, confDirectives []string) (map[string]string, map[string][]string, []string, error) {
+	base := []RedisDirective{}
+	explicit := []RedisDirective{}
+	skipped := []string{}
+
+	if len(confFile) > 0 {
+		var err error
+
+		if base, err = LoadRedisDirectives(confFile); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	for _, directive := range confDirectives {
+		if name, value, err := ParseRedisDirective(directive); err != nil {
+			return nil, nil, nil, err
+		} else {
+			explicit = append(explicit, RedisDirective{Name: name, Value: value})
+		}
+	}
+
+	all := []RedisDirective{}
+
+	for _, directive := range base {
+		if IsManagedRedisDirective(directive.Name) {
+			if !contains(skipped, directive.Name) {
+				skipped = append(skipped, directive.Name)
+			}
+		} else if IsRepeatableRedisDirective(directive.Name) || !containsDirective(explicit, directive.Name) {
+			all = append(all, directive)
+		}
+	}
+
+	directives, repeated := GroupRedisDirectives(append(all, explicit...))
+
+	if err := ValidateRedisDirectives(directives); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := ValidateRepeatedRedisDirectives(repeated); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return directives, repeated, skipped, nil
+}
+
+func containsDirective(directives []RedisDirective, name string) bool {
+	for _, directive := range directives {
+		if directive.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (self *Controller) Remove(clusterName string, sayYes bool) error {
 	if len(clusterName) < MinClusterNameLength {
 		return ClusterNameRequiredError
@@ -183,36 +494,200 @@ func (self *Controller) Stop(clusterName string) error {
 	}
 }
 
-func (self *Controller) DistributeSlots(clusterName string, replicas int, sayYes bool) error {
-	if cluster, err := self.openCluster(clusterName); err != nil {
+func (self *Controller) DistributeSlots(clusterName string, props DistributionProperties) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	var shards []Shard
+	var distribution *SlotDistributionSpec
+
+	_, err = os.Stat(cluster.SlotPlanFile())
+	interrupted := err == nil
+
+	if props.resume && !interrupted {
+		return NothingToResumeError
+	} else if props.resume {
+		self.view.Echo("Resuming interrupted distribution...")
+
+		if plan, err := LoadSlotPlan(cluster.SlotPlanFile()); err != nil {
+			return err
+		} else if shards, err = cluster.ShardsOf(plan); err != nil {
+			return err
+		}
+	} else if interrupted && len(props.planOut) < 1 {
+		return InterruptedDistributionError
+	} else if len(props.planIn) > 0 {
+		if plan, err := LoadSlotPlan(props.planIn); err != nil {
+			return err
+		} else if shards, err = cluster.ShardsOf(plan); err != nil {
+			return err
+		}
+	} else if spec, err := props.spec(); err != nil {
+		return err
+	} else if shards, err = cluster.PrepareSlotDistribution(spec); err != nil {
 		return err
 	} else {
-		if replicas < 1 && replicas < cluster.NodesCount() {
-			return IllegalReplicaCount(cluster.NodesCount())
+		distribution = &spec
+	}
+
+	self.echoShards(shards)
+
+	if len(props.planOut) > 0 {
+		if err := SaveSlotPlan(props.planOut, NewSlotPlan(shards)); err != nil {
+			return err
+		}
+
+		self.view.Success("The plan has been saved to %s", props.planOut)
+		return nil
+	}
+
+	if !props.sayYes && !self.view.Ask("Do you want to proceed?") {
+		self.view.Aborted()
+		return nil
+	}
+
+	if err := self.distribute(clusterName, cluster, shards); err != nil {
+		return err
+	}
+
+	conf := cluster.Conf()
+
+	// Distribution applied from the plan is described by the shards so export and clone don't report the stale one
+	if distribution == nil {
+		antiAffinity := conf.Distribution == nil || conf.Distribution.AntiAffinity
+
+		if spec, ok := DistributionSpecOfShards(shards, antiAffinity); !ok {
+			return nil
+		} else {
+			distribution = &spec
 		}
+	}
 
-		shards := cluster.PrepareSlotDistribution(replicas)
+	conf.Distribution = distribution
 
-		for _, shard := range shards {
-			slotRange := fmt.Sprintf("%v-%v", shard.FromSlot, shard.ToSlot-1)
+	_, err = self.clusterSet.Update(clusterName, conf)
+	return err
+}
 
-			slaves := make([]string, len(shard.SlavesAddresses))
+func (self *Controller) echoShards(shards []Shard) {
+	for _, shard := range shards {
+		slotRange := "-"
 
-			for i, slaveAddress := range shard.SlavesAddresses {
-				slaves[i] = slaveAddress.String()
-			}
+		if len(shard.Slots) > 0 {
+			slotRange = FormatSlotRanges(shard.Slots)
+		}
+
+		slaves := make([]string, len(shard.SlavesAddresses))
+
+		for i, slaveAddress := range shard.SlavesAddresses {
+			slaves[i] = slaveAddress.String()
+		}
 
-			self.view.Echo("%-11s %20s %v", slotRange, bold(shard.MasterAddress), strings.Join(slaves, " "))
+		self.view.Echo("%-11s %20s %v", slotRange, bold(shard.MasterAddress), strings.Join(slaves, " "))
+	}
+}
+
+// Applies the distribution keeping the plan file until it completes so that interrupted distribution can be resumed
+func (self *Controller) distribute(clusterName string, cluster *Cluster, shards []Shard) error {
+	if err := SaveSlotPlan(cluster.SlotPlanFile(), NewSlotPlan(shards)); err != nil {
+		return err
+	}
+
+	if err := self.applySlotDistribution(clusterName, cluster, shards); err != nil {
+		return err
+	}
+
+	return os.Remove(cluster.SlotPlanFile())
+}
+
+// Applies the distribution and waits until the cluster converges reporting each stage
+func (self *Controller) applySlotDistribution(clusterName string, cluster *Cluster, shards []Shard) error {
+	nodes := cluster.NodesOf(shards)
+
+	self.view.Echo("Assigning slots and replicas...")
+
+	if err := cluster.ApplySlotDistribution(shards); err != nil {
+		return err
+	}
+
+	self.view.Echo("Waiting for %v nodes to know each other...", len(nodes))
+
+	if err := cluster.WaitUntilNodesKnown(nodes); err != nil {
+		return err
+	}
+
+	self.view.Echo("Waiting for replicas to link to their masters...")
+
+	if err := cluster.WaitUntilReplicasLinked(shards); err != nil {
+		return err
+	}
+
+	self.view.Echo("Waiting for cluster state to become ok...")
+
+	if err := cluster.WaitUntilClusterOk(nodes); err != nil {
+		return err
+	}
+
+	self.view.Success("Slots of cluster %s have been distributed", bold(clusterName))
+	return nil
+}
+
+// Builds distribution spec from the spec file with the options given on the command line applied on top of it
+func (self DistributionProperties) spec() (SlotDistributionSpec, error) {
+	result := DefaultSlotDistributionSpec()
+
+	if len(self.specFile) > 0 {
+		var err error
+
+		if result, err = LoadSlotDistributionSpec(self.specFile); err != nil {
+			return result, err
 		}
+	}
+
+	if self.replicasSet {
+		result.Replicas = self.replicas
+	}
 
-		if self.view.Ask("Do you want to proceed?") {
-			cluster.ApplySlotDistribution(shards)
+	for _, master := range self.masters {
+		if port, err := strconv.Atoi(master); err != nil {
+			return result, err
 		} else {
-			self.view.Aborted()
+			result.Masters = append(result.Masters, port)
 		}
+	}
 
-		return nil
+	for _, emptyMaster := range self.emptyMasters {
+		if port, err := strconv.Atoi(emptyMaster); err != nil {
+			return result, err
+		} else {
+			result.EmptyMasters = append(result.EmptyMasters, port)
+		}
+	}
+
+	for _, replicaCount := range self.replicaCounts {
+		if port, count, err := ParseReplicaCount(replicaCount); err != nil {
+			return result, err
+		} else {
+			result.ReplicaCounts[port] = count
+		}
+	}
+
+	for _, weight := range self.weights {
+		if port, value, err := ParseNodeWeight(weight); err != nil {
+			return result, err
+		} else {
+			result.Weights[port] = value
+		}
 	}
+
+	if self.noAntiAffinity {
+		result.AntiAffinity = false
+	}
+
+	return result, nil
 }
 
 func (self *Controller) List(short bool) error {
@@ -284,18 +759,1592 @@ func (self *Controller) Ps(clusterName string, short bool) error {
 				state = red("ERROR")
 			} else {
 				if pid > 0 {
-					state = green("UP")
+					state = green("UP  ")
 				} else {
 					state = yellow("DOWN")
 				}
 			}
 
-			self.view.Echo("%-5v %-20s %s", pid, node.Address(), state)
+			if socket := node.UnixSocket(); len(socket) > 0 {
+				self.view.Echo("%-5v %-20s %s %s", pid, node.Address(), state, socket)
+			} else {
+				self.view.Echo("%-5v %-20s %s", pid, node.Address(), state)
+			}
 		}
 		return nil
 	}
 }
 
+func (self *Controller) AddNode(clusterName string, count int, replicaOf int, asMaster bool) error {
+	if count < 1 {
+		return IllegalAddNodeCountError
+	}
+
+	if replicaOf > 0 && asMaster {
+		return ConflictingRoleOptionsError
+	}
+
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	var master *Node
+
+	if replicaOf > 0 {
+		if node, ok := cluster.NodeByPort(replicaOf); !ok {
+			return NodeDoesNotExistError(replicaOf)
+		} else if role, err := node.Role(); err != nil {
+			return err
+		} else if role != RoleMaster {
+			return NodeIsNotMasterError(node.Address())
+		} else {
+			master = node
+		}
+	}
+
+	seed, err := cluster.RandomNode(true)
+
+	if err != nil {
+		return err
+	}
+
+	usedPorts, err := self.clusterSet.UsedPorts()
+
+	if err != nil {
+		return err
+	}
+
+	ports, err := allocatePorts(cluster.Conf().ListenPorts, usedPorts, count)
+
+	if err != nil {
+		return err
+	}
+
+	role := "masters"
+
+	if master != nil {
+		role = fmt.Sprintf("replicas of %s", master.Address())
+	}
+
+	if !self.view.Ask("Add %v nodes listening on ports %v to cluster %s as %s?", count, ports, bold(clusterName), role) {
+		self.view.Aborted()
+		return nil
+	}
+
+	// The configuration is copied so that it is saved only once the nodes have joined
+	conf := cluster.Conf().WithPorts(map[int]int{})
+
+	for _, port := range ports {
+		if len(conf.NodeBind) > 0 {
+			var masterHost string
+
+			if master != nil {
+				masterHost = master.Host()
+			}
+
+			conf.NodeBind[port] = conf.LeastLoadedHost(masterHost)
+		}
+
+		conf.ListenPorts = append(conf.ListenPorts, port)
+	}
+
+	cluster = self.clusterSet.Prepare(clusterName, conf)
+	nodes := make([]*Node, len(ports))
+
+	for i, port := range ports {
+		nodes[i], _ = cluster.NodeByPort(port)
+	}
+
+	self.view.Echo("Starting and joining %v nodes...", len(nodes))
+
+	if err := cluster.JoinNodes(seed, nodes, master); err != nil {
+		// Nodes which have already been met are left failed in the node tables until 'fix' forgets them
+		for _, node := range nodes {
+			if isUp, _ := node.IsUp(); isUp {
+				node.Stop()
+			}
+
+			node.Remove()
+		}
+
+		return err
+	}
+
+	if _, err := self.clusterSet.Update(clusterName, conf); err != nil {
+		return err
+	}
+
+	self.view.Success("Added %v nodes to cluster %s", len(nodes), bold(clusterName))
+	return nil
+}
+
+func (self *Controller) RemoveNode(clusterName string, port int, force bool) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	node, ok := cluster.NodeByPort(port)
+
+	if !ok {
+		return NodeDoesNotExistError(port)
+	}
+
+	if cluster.NodesCount() <= MinNodesCount {
+		return TooFewNumberOfNodesError()
+	}
+
+	nodeIsUp, err := node.IsUp()
+
+	if err != nil {
+		return err
+	}
+
+	seed, err := cluster.OtherUpNode(node)
+
+	if err != nil {
+		return err
+	}
+
+	var topology Topology
+	var info ClusterNodeInfo
+	var isKnown bool
+
+	if seed != nil {
+		if topology, err = seed.ClusterTopology(); err != nil {
+			return err
+		}
+
+		info, isKnown = topology.ByPort(port)
+	}
+
+	var targets []*Node
+	var moves [][]int
+
+	if isKnown && info.IsMaster() && info.SlotCount() > 0 {
+		var targetSlotCounts []int
+
+		for _, master := range topology.Masters() {
+			if target, ok := cluster.NodeOf(master); ok && master.Id != info.Id {
+				if isUp, err := target.IsUp(); err != nil {
+					return err
+				} else if isUp {
+					targets = append(targets, target)
+					targetSlotCounts = append(targetSlotCounts, master.SlotCount())
+				}
+			}
+		}
+
+		if !nodeIsUp || len(targets) < 1 {
+			if !force {
+				return SlotsWouldBeUncoveredError(info.SlotCount())
+			}
+
+			self.view.Echo("%s %v slots will be left uncovered", yellow("WARNING"), info.SlotCount())
+		} else {
+			moves = splitSlots(SlotsOf(info.Slots), targetSlotCounts)
+
+			for i, target := range targets {
+				if len(moves[i]) > 0 {
+					self.view.Echo("%-20s %s", target.Address(), FormatSlotRanges(SlotRangesOf(moves[i])))
+				}
+			}
+		}
+	}
+
+	if !self.view.Ask("Remove node %s from cluster %s?", bold(node.Address()), bold(clusterName)) {
+		self.view.Aborted()
+		return nil
+	}
+
+	var newMaster *Node
+	var newMasterSlotCount int
+
+	for i, target := range targets {
+		if len(moves[i]) < 1 {
+			continue
+		}
+
+		self.view.Echo("Migrating %v slots to %s...", len(moves[i]), target.Address())
+
+		migration, err := cluster.NewSlotMigration(node, target, DefaultMigrationOptions())
+
+		if err != nil {
+			return err
+		}
+
+		for _, slot := range moves[i] {
+			if _, err := migration.MigrateSlot(slot); err != nil {
+				return err
+			}
+		}
+
+		if newMaster == nil || len(moves[i]) > newMasterSlotCount {
+			newMaster = target
+			newMasterSlotCount = len(moves[i])
+		}
+	}
+
+	if isKnown && info.IsMaster() {
+		if err := self.reassignSlaves(cluster, topology, info, newMaster); err != nil {
+			return err
+		}
+	}
+
+	if nodeIsUp {
+		self.view.Echo("Stopping node %s...", node.Address())
+
+		if err := node.Stop(); err != nil {
+			return err
+		}
+
+		err := waitUntil(fmt.Sprintf("node %s to stop", node.Address()), ClusterOperationTimeout, func() (bool, error) {
+			isUp, err := node.IsUp()
+			return !isUp, err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if isKnown {
+		for _, other := range cluster.Nodes() {
+			if other == node {
+				continue
+			}
+
+			if isUp, err := other.IsUp(); err != nil {
+				return err
+			} else if !isUp {
+				continue
+			} else if err := other.ClusterForget(info.Id); err != nil && !isUnknownNodeError(err) {
+				return err
+			}
+		}
+	}
+
+	if err := node.Remove(); err != nil {
+		return err
+	}
+
+	conf := cluster.Conf()
+	conf.RemoveNode(port)
+
+	if _, err := self.clusterSet.Update(clusterName, conf); err != nil {
+		return err
+	}
+
+	self.view.Success("Node %s has been removed from cluster %s", node.Address(), bold(clusterName))
+	return nil
+}
+
+// Moves replicas of the master which is going to be removed to the new master so they are not orphaned
+func (self *Controller) reassignSlaves(cluster *Cluster, topology Topology, master ClusterNodeInfo, newMaster *Node) error {
+	slaves := topology.SlavesOf(master.Id)
+
+	if len(slaves) < 1 {
+		return nil
+	}
+
+	if newMaster == nil {
+		for _, info := range topology.Masters() {
+			if node, ok := cluster.NodeOf(info); ok && info.Id != master.Id && info.SlotCount() > 0 {
+				newMaster = node
+				break
+			}
+		}
+	}
+
+	if newMaster == nil {
+		return nil
+	}
+
+	newMasterId, err := newMaster.Id()
+
+	if err != nil {
+		return err
+	}
+
+	for _, slave := range slaves {
+		if node, ok := cluster.NodeOf(slave); !ok {
+			continue
+		} else if isUp, err := node.IsUp(); err != nil {
+			return err
+		} else if !isUp {
+			continue
+		} else {
+			self.view.Echo("Moving replica %s to master %s...", node.Address(), newMaster.Address())
+
+			if err := node.ClusterReplicate(newMasterId); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (self *Controller) Reshard(
+	clusterName string,
+	fromPort int,
+	toPort int,
+	slotCount int,
+	slotRanges []string,
+	options MigrationOptions) error {
+
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	if fromPort == toPort {
+		return SameSourceAndTargetError
+	}
+
+	source, ok := cluster.NodeByPort(fromPort)
+
+	if !ok {
+		return NodeDoesNotExistError(fromPort)
+	}
+
+	target, ok := cluster.NodeByPort(toPort)
+
+	if !ok {
+		return NodeDoesNotExistError(toPort)
+	}
+
+	topology, err := source.ClusterTopology()
+
+	if err != nil {
+		return err
+	}
+
+	sourceInfo, _ := topology.Myself()
+	targetInfo, isKnown := topology.ByPort(toPort)
+
+	if !sourceInfo.IsMaster() {
+		return NodeIsNotMasterError(source.Address())
+	}
+
+	if !isKnown {
+		return NodeIsNotKnownError(target.Address())
+	}
+
+	if !targetInfo.IsMaster() {
+		return NodeIsNotMasterError(target.Address())
+	}
+
+	migration, err := cluster.NewSlotMigration(source, target, options)
+
+	if err != nil {
+		return err
+	}
+
+	openSlots, err := migration.OpenSlots()
+
+	if err != nil {
+		return err
+	}
+
+	var slots []int
+
+	if len(slotRanges) > 0 {
+		ranges := make([]SlotRange, len(slotRanges))
+
+		for i, slotRange := range slotRanges {
+			if ranges[i], err = ParseSlotRange(slotRange); err != nil {
+				return err
+			}
+		}
+
+		for _, slot := range SlotsOf(ranges) {
+			if containsSlot(SlotRangesOf(openSlots), slot) {
+				continue
+			} else if !containsSlot(sourceInfo.Slots, slot) {
+				return SlotIsNotOwnedError(slot, source.Address())
+			}
+
+			slots = append(slots, slot)
+		}
+	} else if slotCount > 0 {
+		for _, slot := range SlotsOf(sourceInfo.Slots) {
+			if len(slots) < slotCount && !containsSlot(SlotRangesOf(openSlots), slot) {
+				slots = append(slots, slot)
+			}
+		}
+
+		if len(slots) < slotCount {
+			return NotEnoughSlotsError(len(slots), source.Address())
+		}
+	} else if len(openSlots) < 1 {
+		return SlotsRequiredError
+	}
+
+	if len(openSlots) > 0 {
+		self.view.Echo(
+			"%s Migration of slots %s was interrupted and will be completed",
+			yellow("WARNING"),
+			FormatSlotRanges(SlotRangesOf(openSlots)))
+	}
+
+	if len(slots) > 0 {
+		self.view.Echo("%-20s -> %-20s %s", source.Address(), target.Address(), FormatSlotRanges(SlotRangesOf(slots)))
+	}
+
+	if !self.view.Ask("Move %v slots with their keys?", len(openSlots)+len(slots)) {
+		self.view.Aborted()
+		return nil
+	}
+
+	total := len(openSlots) + len(slots)
+	keysMoved := 0
+
+	for i, slot := range append(openSlots, slots...) {
+		var moved int
+
+		if i < len(openSlots) {
+			moved, err = migration.ResumeSlot(slot)
+		} else {
+			moved, err = migration.MigrateSlot(slot)
+		}
+
+		keysMoved += moved
+
+		if err != nil {
+			if i > 0 {
+				self.view.Echo("")
+			}
+
+			return err
+		}
+
+		self.view.Progress(i+1, total, "Slot %v, %v keys moved", slot, keysMoved)
+	}
+
+	self.view.Success("Moved %v slots and %v keys from %s to %s", total, keysMoved, source.Address(), target.Address())
+	return nil
+}
+
+func (self *Controller) Rebalance(
+	clusterName string,
+	weightDescs []string,
+	threshold float64,
+	options MigrationOptions) error {
+
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	seed, err := cluster.RandomNode(true)
+
+	if err != nil {
+		return err
+	}
+
+	topology, err := seed.ClusterTopology()
+
+	if err != nil {
+		return err
+	}
+
+	masters := topology.Masters()
+	weights := make(map[string]float64)
+
+	for _, weightDesc := range weightDescs {
+		port, weight, err := ParseNodeWeight(weightDesc)
+
+		if err != nil {
+			return err
+		}
+
+		info, ok := topology.ByPort(port)
+
+		if !ok {
+			return NodeDoesNotExistError(port)
+		}
+
+		if !info.IsMaster() {
+			return NodeIsNotMasterError(info.Address)
+		}
+
+		weights[info.Id] = weight
+	}
+
+	moves, err := PlanRebalance(masters, weights, threshold)
+
+	if err != nil {
+		return err
+	}
+
+	if len(moves) < 1 {
+		self.view.Success("Slots of cluster %s are already balanced", bold(clusterName))
+		return nil
+	}
+
+	total := 0
+
+	for _, move := range moves {
+		source, _ := topology.ById(move.SourceId)
+		target, _ := topology.ById(move.TargetId)
+
+		self.view.Echo(
+			"%-20s -> %-20s %5v %s",
+			source.Address,
+			target.Address,
+			len(move.Slots),
+			FormatSlotRanges(SlotRangesOf(move.Slots)))
+
+		total += len(move.Slots)
+	}
+
+	if !self.view.Ask("Move %v slots with their keys?", total) {
+		self.view.Aborted()
+		return nil
+	}
+
+	done := 0
+	keysMoved := 0
+
+	for _, move := range moves {
+		sourceInfo, _ := topology.ById(move.SourceId)
+		targetInfo, _ := topology.ById(move.TargetId)
+
+		source, ok := cluster.NodeOf(sourceInfo)
+
+		if !ok {
+			return NodeDoesNotExistError(sourceInfo.Address.Port)
+		}
+
+		target, ok := cluster.NodeOf(targetInfo)
+
+		if !ok {
+			return NodeDoesNotExistError(targetInfo.Address.Port)
+		}
+
+		migration, err := cluster.NewSlotMigration(source, target, options)
+
+		if err != nil {
+			return err
+		}
+
+		for _, slot := range move.Slots {
+			moved, err := migration.MigrateSlot(slot)
+			keysMoved += moved
+
+			if err != nil {
+				if done > 0 {
+					self.view.Echo("")
+				}
+
+				return err
+			}
+
+			done += 1
+			self.view.Progress(done, total, "Slot %v, %v keys moved", slot, keysMoved)
+		}
+	}
+
+	self.view.Success("Moved %v slots and %v keys", total, keysMoved)
+	return nil
+}
+
+// Promotes the replica to master with CLUSTER FAILOVER. The replica is either specified explicitly, chosen among
+// replicas of specified master or chosen randomly
+func (self *Controller) Failover(
+	clusterName string,
+	replicaPort int,
+	masterPort int,
+	random bool,
+	force bool,
+	takeover bool) error {
+
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	selectors := 0
+
+	for _, selected := range []bool{replicaPort > 0, masterPort > 0, random} {
+		if selected {
+			selectors += 1
+		}
+	}
+
+	if selectors != 1 {
+		return FailoverTargetRequiredError
+	}
+
+	option := ""
+
+	if force && takeover {
+		return ConflictingFailoverModesError
+	} else if force {
+		option = "FORCE"
+	} else if takeover {
+		option = "TAKEOVER"
+	}
+
+	seed, err := cluster.RandomNode(true)
+
+	if err != nil {
+		return err
+	}
+
+	topology, err := seed.ClusterTopology()
+
+	if err != nil {
+		return err
+	}
+
+	var replicaInfo ClusterNodeInfo
+	var masterInfo ClusterNodeInfo
+
+	if replicaPort > 0 {
+		var ok bool
+
+		if replicaInfo, ok = topology.ByPort(replicaPort); !ok {
+			return NodeDoesNotExistError(replicaPort)
+		} else if !replicaInfo.IsSlave() {
+			return NodeIsNotSlaveError(replicaInfo.Address)
+		}
+
+		masterInfo, _ = topology.ById(replicaInfo.MasterId)
+	} else {
+		candidates := []ClusterNodeInfo{}
+
+		if masterPort > 0 {
+			var ok bool
+
+			if masterInfo, ok = topology.ByPort(masterPort); !ok {
+				return NodeDoesNotExistError(masterPort)
+			} else if !masterInfo.IsMaster() {
+				return NodeIsNotMasterError(masterInfo.Address)
+			}
+
+			candidates = append(candidates, masterInfo)
+		} else {
+			candidates = topology.Masters()
+		}
+
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		r.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+
+		for _, candidate := range candidates {
+			for _, slave := range topology.SlavesOf(candidate.Id) {
+				if !slave.IsFailed() {
+					masterInfo = candidate
+					replicaInfo = slave
+					break
+				}
+			}
+
+			if len(replicaInfo.Id) > 0 {
+				break
+			}
+		}
+
+		if len(replicaInfo.Id) < 1 && masterPort > 0 {
+			return MasterHasNoReplicasError(masterInfo.Address)
+		} else if len(replicaInfo.Id) < 1 {
+			return NoReplicasAvailableError
+		}
+	}
+
+	replica, ok := cluster.NodeOf(replicaInfo)
+
+	if !ok {
+		return NodeDoesNotExistError(replicaInfo.Address.Port)
+	}
+
+	self.view.Echo("Failing over master %s to replica %s...", masterInfo.Address, replica.Address())
+
+	startedAt := time.Now()
+
+	if err := replica.ClusterFailover(option); err != nil {
+		return err
+	}
+
+	err = waitUntil(fmt.Sprintf("replica %s to be promoted", replica.Address()), ClusterOperationTimeout, func() (bool, error) {
+		role, err := replica.Role()
+		return role == RoleMaster, err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	promotedIn := time.Since(startedAt)
+	self.view.Echo("Replica %s has been promoted in %v", replica.Address(), promotedIn.Round(time.Millisecond))
+
+	master, ok := cluster.NodeOf(masterInfo)
+
+	if !ok {
+		return NodeDoesNotExistError(masterInfo.Address.Port)
+	}
+
+	if isUp, err := master.IsUp(); err != nil {
+		return err
+	} else if !isUp {
+		self.view.Echo("%s Old master %s is down and will rejoin as a replica once started", yellow("WARNING"), master.Address())
+		self.view.Success("Failover completed in %v", promotedIn.Round(time.Millisecond))
+		return nil
+	}
+
+	err = waitUntil(fmt.Sprintf("master %s to become replica", master.Address()), ClusterOperationTimeout, func() (bool, error) {
+		topology, err := master.ClusterTopology()
+
+		if err != nil {
+			return false, err
+		}
+
+		myself, ok := topology.Myself()
+		return ok && myself.IsSlave() && myself.MasterId == replicaInfo.Id, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	demotedIn := time.Since(startedAt)
+	self.view.Echo("Old master %s has become replica in %v", master.Address(), demotedIn.Round(time.Millisecond))
+	self.view.Success("Failover completed in %v", demotedIn.Round(time.Millisecond))
+	return nil
+}
+
+// Moves the replica under another master with CLUSTER REPLICATE
+func (self *Controller) Replicate(clusterName string, replicaPort int, masterPort int, force bool) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	if masterPort < 1 {
+		return MasterPortRequiredError
+	}
+
+	if replicaPort == masterPort {
+		return SameSourceAndTargetError
+	}
+
+	seed, err := cluster.RandomNode(true)
+
+	if err != nil {
+		return err
+	}
+
+	topology, err := seed.ClusterTopology()
+
+	if err != nil {
+		return err
+	}
+
+	replicaInfo, ok := topology.ByPort(replicaPort)
+
+	if !ok {
+		return NodeDoesNotExistError(replicaPort)
+	}
+
+	masterInfo, ok := topology.ByPort(masterPort)
+
+	if !ok {
+		return NodeDoesNotExistError(masterPort)
+	}
+
+	if !masterInfo.IsMaster() {
+		return NodeIsNotMasterError(masterInfo.Address)
+	}
+
+	if replicaInfo.IsMaster() && replicaInfo.SlotCount() > 0 {
+		return NodeServesSlotsError(replicaInfo.Address, replicaInfo.SlotCount())
+	}
+
+	if replicaInfo.MasterId == masterInfo.Id {
+		self.view.Success("Node %s is already a replica of %s", replicaInfo.Address, masterInfo.Address)
+		return nil
+	}
+
+	if oldMaster, ok := topology.ById(replicaInfo.MasterId); ok && oldMaster.IsMaster() && oldMaster.SlotCount() > 0 {
+		available := 0
+
+		for _, slave := range topology.SlavesOf(oldMaster.Id) {
+			if !slave.IsFailed() {
+				available += 1
+			}
+		}
+
+		if available <= 1 {
+			if !force {
+				return MasterWouldBeOrphanedError(oldMaster.Address)
+			}
+
+			self.view.Echo("%s Master %s will be left without replicas", yellow("WARNING"), oldMaster.Address)
+		}
+	}
+
+	if err := self.replicate(cluster, replicaInfo, masterInfo); err != nil {
+		return err
+	}
+
+	self.view.Success("Node %s is now a replica of %s", replicaInfo.Address, masterInfo.Address)
+	return nil
+}
+
+// Spreads replicas evenly across masters serving slots
+func (self *Controller) BalanceReplicas(clusterName string) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	seed, err := cluster.RandomNode(true)
+
+	if err != nil {
+		return err
+	}
+
+	topology, err := seed.ClusterTopology()
+
+	if err != nil {
+		return err
+	}
+
+	moves := PlanReplicaBalance(topology)
+
+	if len(moves) < 1 {
+		self.view.Success("Replicas of cluster %s are already balanced", bold(clusterName))
+		return nil
+	}
+
+	for _, move := range moves {
+		replicaInfo, _ := topology.ById(move.ReplicaId)
+		masterInfo, _ := topology.ById(move.MasterId)
+
+		self.view.Echo("%-20s -> %-20s", replicaInfo.Address, masterInfo.Address)
+	}
+
+	if !self.view.Ask("Move %v replicas?", len(moves)) {
+		self.view.Aborted()
+		return nil
+	}
+
+	for _, move := range moves {
+		replicaInfo, _ := topology.ById(move.ReplicaId)
+		masterInfo, _ := topology.ById(move.MasterId)
+
+		if err := self.replicate(cluster, replicaInfo, masterInfo); err != nil {
+			return err
+		}
+	}
+
+	self.view.Success("Moved %v replicas", len(moves))
+	return nil
+}
+
+func (self *Controller) replicate(cluster *Cluster, replicaInfo ClusterNodeInfo, masterInfo ClusterNodeInfo) error {
+	replica, ok := cluster.NodeOf(replicaInfo)
+
+	if !ok {
+		return NodeDoesNotExistError(replicaInfo.Address.Port)
+	}
+
+	self.view.Echo("Moving replica %s to master %s...", replica.Address(), masterInfo.Address)
+
+	if err := replica.ClusterReplicate(masterInfo.Id); err != nil {
+		return err
+	}
+
+	return waitUntil(fmt.Sprintf("node %s to become replica", replica.Address()), ClusterOperationTimeout, func() (bool, error) {
+		topology, err := replica.ClusterTopology()
+
+		if err != nil {
+			return false, err
+		}
+
+		myself, ok := topology.Myself()
+		return ok && myself.IsSlave() && myself.MasterId == masterInfo.Id, nil
+	})
+}
+
+// Detects and repairs common broken states of the cluster after showing the plan
+func (self *Controller) Fix(clusterName string, options MigrationOptions) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	fix, err := cluster.PrepareFix()
+
+	if err != nil {
+		return err
+	}
+
+	if fix.Empty() {
+		self.view.Success("Cluster %s has nothing to fix", bold(clusterName))
+		return nil
+	}
+
+	for _, info := range fix.Forget {
+		self.view.Echo("%-10s %s %s", "forget", info.Id, info.Address)
+	}
+
+	for _, node := range fix.Meet {
+		self.view.Echo("%-10s %s", "meet", node.Address())
+	}
+
+	for _, openSlot := range fix.OpenSlots {
+		self.view.Echo("%-10s %v %s -> %s", "migrate", openSlot.Slot, openSlot.SourceId, openSlot.TargetId)
+	}
+
+	for _, assignment := range fix.Assignments {
+		self.view.Echo("%-10s %s %s", "assign", assignment.Node.Address(), FormatSlotRanges(SlotRangesOf(assignment.Slots)))
+	}
+
+	if !self.view.Ask("Do you want to apply the fix?") {
+		self.view.Aborted()
+		return nil
+	}
+
+	if err := cluster.ApplyFix(fix, options); err != nil {
+		return err
+	}
+
+	self.view.Success("Cluster %s has been fixed", bold(clusterName))
+	return nil
+}
+
+// Returns nodes of the cluster to the state before slots were distributed. Nodes are left started. Hard reset also
+// gives nodes new ids and resets their epochs
+func (self *Controller) Reset(clusterName string, hard bool, sayYes bool) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	if !sayYes && !self.view.Ask("Reset cluster %s? All the data will be lost", bold(clusterName)) {
+		self.view.Aborted()
+		return nil
+	}
+
+	self.view.Echo("Starting nodes...")
+
+	if err := cluster.StartAndWait(); err != nil {
+		return err
+	}
+
+	masters := []*Node{}
+
+	// Replicas are reset first since they drop their data becoming masters while masters refuse the reset
+	// until they are flushed
+	for _, node := range cluster.Nodes() {
+		if role, err := node.Role(); err != nil {
+			return err
+		} else if role == RoleMaster {
+			masters = append(masters, node)
+		} else if err := node.ClusterReset(hard); err != nil {
+			return err
+		}
+	}
+
+	for _, node := range masters {
+		if err := node.FlushAll(); err != nil {
+			return err
+		}
+
+		if err := node.ClusterReset(hard); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(cluster.SlotPlanFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	self.view.Success("Cluster %s has been reset", bold(clusterName))
+	return nil
+}
+
+// Captures data and configuration of every node. Running nodes save their data first
+func (self *Controller) Snapshot(clusterName string, snapshotName string) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateSnapshotName(snapshotName); err != nil {
+		return err
+	}
+
+	if cluster.SnapshotExists(snapshotName) {
+		return SnapshotExistsError(snapshotName)
+	}
+
+	nodes, splitIndex, err := cluster.NodesByState()
+
+	if err != nil {
+		return err
+	}
+
+	if splitIndex > 0 {
+		self.view.Echo("Saving data of %v running nodes...", splitIndex)
+		saves := make([]func() error, splitIndex)
+
+		for i, node := range nodes[:splitIndex] {
+			saves[i] = node.Persist
+		}
+
+		if err := parallel(saves); err != nil {
+			return err
+		}
+	}
+
+	if err := cluster.CreateSnapshot(snapshotName); err != nil {
+		return err
+	}
+
+	self.view.Success("Snapshot %s of cluster %s has been created", bold(snapshotName), bold(clusterName))
+	return nil
+}
+
+// Rolls the cluster back to the snapshot. The cluster is stopped for the time of restore and started again if it
+// was running
+func (self *Controller) Restore(clusterName string, snapshotName string, sayYes bool) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	if !cluster.SnapshotExists(snapshotName) {
+		return SnapshotDoesNotExistError(snapshotName)
+	}
+
+	if !sayYes && !self.view.Ask("Restore cluster %s from snapshot %s? Current data will be lost", bold(clusterName), bold(snapshotName)) {
+		self.view.Aborted()
+		return nil
+	}
+
+	stats, err := cluster.Stats()
+
+	if err != nil {
+		return err
+	}
+
+	if stats.nodesUp > 0 {
+		self.view.Echo("Stopping nodes...")
+
+		if err := cluster.StopAndWait(); err != nil {
+			return err
+		}
+	}
+
+	if err := cluster.RestoreSnapshot(snapshotName); err != nil {
+		return err
+	}
+
+	// Nodes regenerate their configuration from cluster.yml on start so it is restored as well
+	if conf, err := cluster.SnapshotConf(snapshotName); os.IsNotExist(err) {
+		self.view.Echo("%s Snapshot %s has no cluster configuration. The current one is kept", yellow("WARNING"), bold(snapshotName))
+	} else if err != nil {
+		return err
+	} else if cluster, err = self.clusterSet.Update(clusterName, conf); err != nil {
+		return err
+	}
+
+	if stats.nodesUp > 0 {
+		self.view.Echo("Starting nodes...")
+
+		if err := cluster.StartAndWait(); err != nil {
+			return err
+		}
+	}
+
+	self.view.Success("Cluster %s has been restored from snapshot %s", bold(clusterName), bold(snapshotName))
+	return nil
+}
+
+func (self *Controller) Snapshots(clusterName string) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := cluster.Snapshots()
+
+	if err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		self.view.Echo("%s", snapshot)
+	}
+
+	return nil
+}
+
+func (self *Controller) RemoveSnapshot(clusterName string, snapshotName string) error {
+	cluster, err := self.openCluster(clusterName)
+
+	if err != nil {
+		return err
+	}
+
+	if !cluster.SnapshotExists(snapshotName) {
+		return SnapshotDoesNotExistError(snapshotName)
+	}
+
+	if err := cluster.RemoveSnapshot(snapshotName); err != nil {
+		return err
+	}
+
+	self.view.Success("Snapshot %s of cluster %s has been removed", bold(snapshotName), bold(clusterName))
+	return nil
+}
+
+func (self *Controller) Mv(oldName string, newName string) error {
+	cluster, err := self.openCluster(oldName)
+
+	if err != nil {
+		return err
+	}
+
+	if len(newName) < MinClusterNameLength {
+		return ClusterNameRequiredError
+	}
+
+	if !clusterNameRegEx.MatchString(newName) {
+		return IllegalClusterNameError
+	}
+
+	if self.clusterSet.Exists(newName) {
+		return ClusterExistsError(newName)
+	}
+
+	if stats, err := cluster.Stats(); err != nil {
+		return err
+	} else if stats.nodesUp > 0 {
+		return ClusterIsRunningError(oldName)
+	}
+
+	if _, err := self.clusterSet.Rename(oldName, newName); err != nil {
+		return err
+	}
+
+	self.view.Success("Cluster %s has been renamed to %s", bold(oldName), bold(newName))
+	return nil
+}
+
+// Copies the stopped cluster with its topology and data to new ports. Without start port the ports following the
+// highest port of the source are used
+func (self *Controller) Clone(srcName string, dstName string, startPort int) error {
+	src, err := self.openCluster(srcName)
+
+	if err != nil {
+		return err
+	}
+
+	if len(dstName) < MinClusterNameLength {
+		return ClusterNameRequiredError
+	}
+
+	if !clusterNameRegEx.MatchString(dstName) {
+		return IllegalClusterNameError
+	}
+
+	if self.clusterSet.Exists(dstName) {
+		return ClusterExistsError(dstName)
+	}
+
+	if stats, err := src.Stats(); err != nil {
+		return err
+	} else if stats.nodesUp > 0 {
+		return ClusterIsRunningError(srcName)
+	}
+
+	srcPorts := src.Conf().ListenPorts
+	usedPorts, err := self.clusterSet.UsedPorts()
+
+	if err != nil {
+		return err
+	}
+
+	var ports []int
+
+	if startPort > 0 {
+		maxPort := MaxTcpPort - RedisGossipPortIncrement - len(srcPorts)
+
+		if startPort < MinTcpPort || startPort > maxPort-1 {
+			return PortOutOfRangeError(maxPort)
+		}
+
+		for i := range srcPorts {
+			if port := startPort + i; containsPort(usedPorts, port) {
+				return PortIsUsedError(port)
+			} else {
+				ports = append(ports, port)
+			}
+		}
+	} else if ports, err = allocatePorts(srcPorts, usedPorts, len(srcPorts)); err != nil {
+		return err
+	}
+
+	portMap := make(map[int]int, len(srcPorts))
+
+	for i, port := range srcPorts {
+		portMap[port] = ports[i]
+	}
+
+	self.view.Echo("Cloning cluster %s to %s...", bold(srcName), bold(dstName))
+
+	if _, err := self.clusterSet.Clone(srcName, dstName, portMap); err != nil {
+		return err
+	}
+
+	self.view.Success(
+		"Cluster %s has been cloned to %s on ports %s",
+		bold(srcName),
+		bold(dstName),
+		formatPorts(ports))
+	return nil
+}
+
+func formatPorts(ports []int) string {
+	result := make([]string, len(ports))
+
+	for i, port := range ports {
+		result[i] = strconv.Itoa(port)
+	}
+
+	return strings.Join(result, ",")
+}
+
+// Allocates ports following the highest port of the cluster skipping the ones used by any known cluster
+func allocatePorts(clusterPorts []int, usedPorts []int, count int) ([]int, error) {
+	maxPort := MaxTcpPort - RedisGossipPortIncrement
+	used := make(map[int]bool, len(usedPorts))
+	port := MinTcpPort
+
+	for _, p := range usedPorts {
+		used[p] = true
+	}
+
+	for _, p := range clusterPorts {
+		used[p] = true
+
+		if p > port {
+			port = p
+		}
+	}
+
+	result := make([]int, 0, count)
+
+	for ; len(result) < count; port++ {
+		if port > maxPort {
+			return nil, NoFreePortsError
+		}
+
+		if !used[port] {
+			result = append(result, port)
+		}
+	}
+
+	return result, nil
+}
+
+// Prints cluster endpoints as shell exports. Usage: eval $(rcm env <cluster>)
+func (self *Controller) Env(clusterName string) error {
+	if cluster, err := self.openCluster(clusterName); err != nil {
+		return err
+	} else {
+		nodes := cluster.Nodes()
+		addresses := make([]string, len(nodes))
+		sockets := make([]string, 0, len(nodes))
+
+		for i, node := range nodes {
+			addresses[i] = node.Address().String()
+
+			if socket := node.UnixSocket(); len(socket) > 0 {
+				sockets = append(sockets, socket)
+			}
+		}
+
+		self.view.Echo("export RCM_CLUSTER='%s'", clusterName)
+		self.view.Echo("export RCM_NODES='%s'", strings.Join(addresses, ","))
+
+		if len(sockets) > 0 {
+			self.view.Echo("export RCM_SOCKETS='%s'", strings.Join(sockets, ","))
+		}
+
+		if cluster.Conf().Tls {
+			self.view.Echo("export RCM_TLS_CA_CERT='%s'", cluster.TlsCa().CertFile)
+			self.view.Echo("export RCM_TLS_CERT='%s'", cluster.TlsClient().CertFile)
+			self.view.Echo("export RCM_TLS_KEY='%s'", cluster.TlsClient().KeyFile)
+		}
+
+		return nil
+	}
+}
+
+func (self *Controller) Persistence(clusterName string, mode string, savePoints []string, appendFsync string) error {
+	if cluster, err := self.openCluster(clusterName); err != nil {
+		return err
+	} else if len(mode) < 1 {
+		self.view.Echo("%s", cluster.Conf().Persistence)
+		return nil
+	} else if persistence, err := NewPersistenceConf(mode, savePoints, appendFsync); err != nil {
+		return err
+	} else if self.view.Ask(
+		"Switch persistence of cluster %s from %s to %s?",
+		bold(clusterName),
+		cluster.Conf().Persistence,
+		bold(persistence)) {
+
+		for _, node := range cluster.Nodes() {
+			if isUp, err := node.IsUp(); err != nil {
+				return err
+			} else if !isUp {
+				self.view.Echo("Node %s is %s. Persistence will be switched on the next start", node.Address(), yellow("DOWN"))
+			} else if err := node.ConfigSetPersistence(persistence); err != nil {
+				return err
+			}
+		}
+
+		conf := cluster.Conf()
+		conf.Persistence = persistence
+
+		if _, err := self.clusterSet.Update(clusterName, conf); err != nil {
+			return err
+		}
+
+		self.view.Success("Persistence of cluster %s has been switched to %s", bold(clusterName), persistence)
+	} else {
+		self.view.Aborted()
+	}
+
+	return nil
+}
+
+func (self *Controller) TlsExport(clusterName string, withClient bool) error {
+	if cluster, err := self.openCluster(clusterName); err != nil {
+		return err
+	} else if !cluster.Conf().Tls {
+		return TlsNotEnabledError
+	} else {
+		self.view.Echo(cluster.TlsCa().CertFile)
+
+		if withClient {
+			self.view.Echo(cluster.TlsClient().CertFile)
+			self.view.Echo(cluster.TlsClient().KeyFile)
+		}
+
+		return nil
+	}
+}
+
+func (self *Controller) ConfigSet(clusterName string, name string, value string, port int, role string) error {
+	name = strings.ToLower(name)
+
+	if cluster, err := self.openCluster(clusterName); err != nil {
+		return err
+	} else if len(name) < 1 {
+		return DirectiveRequiredError
+	} else if IsManagedRedisDirective(name) {
+		return ManagedDirectiveError(name)
+	} else if nodes, err := self.selectNodes(cluster, port, role); err != nil {
+		return err
+	} else {
+		conf := cluster.Conf()
+		allNodes := port < 1 && len(role) < 1
+		applied := []*Node{}
+		previousValues := []string{}
+
+		for _, node := range nodes {
+			if isUp, err := node.IsUp(); err != nil {
+				self.rollbackConfigSet(name, applied, previousValues)
+				return err
+			} else if !isUp {
+				self.view.Echo("Node %s is %s. The value will be applied on the next start", node.Address(), yellow("DOWN"))
+			} else if previousValue, err := node.ConfigGet(name); err != nil {
+				self.rollbackConfigSet(name, applied, previousValues)
+				return err
+			} else if err := node.ConfigSet(name, value); err != nil {
+				self.rollbackConfigSet(name, applied, previousValues)
+				return err
+			} else {
+				applied = append(applied, node)
+				previousValues = append(previousValues, previousValue)
+			}
+
+			if !allNodes {
+				conf.SetNodeDirective(node.Port(), name, value)
+			}
+		}
+
+		if allNodes {
+			conf.SetDirective(name, value)
+		}
+
+		if _, err := self.clusterSet.Update(clusterName, conf); err != nil {
+			self.rollbackConfigSet(name, applied, previousValues)
+			return err
+		}
+
+		self.view.Success("Directive %s has been set to '%s' on %v nodes", bold(name), value, len(nodes))
+		return nil
+	}
+}
+
+// Returns the value with the greatest count. Ties are broken in favour of the value which comes first so the same nodes
+// are reported as drifted every time
+func mostCommonValue(values []string, counts map[string]int) string {
+	if len(values) < 1 {
+		return ""
+	}
+
+	result := values[0]
+
+	for _, value := range values[1:] {
+		if counts[value] > counts[result] {
+			result = value
+		}
+	}
+
+	return result
+}
+
+// Restores previous values on the nodes the directive has been already applied to, so running nodes don't diverge
+// from the stored configuration
+func (self *Controller) rollbackConfigSet(name string, nodes []*Node, previousValues []string) {
+	for i, node := range nodes {
+		if err := node.ConfigSet(name, previousValues[i]); err != nil {
+			self.view.Echo("%s Can't restore %s on node %s: %v", yellow("WARNING"), bold(name), node.Address(), err)
+		}
+	}
+}
+
+func (self *Controller) ConfigGet(clusterName string, name string) error {
+	name = strings.ToLower(name)
+
+	if cluster, err := self.openCluster(clusterName); err != nil {
+		return err
+	} else if len(name) < 1 {
+		return DirectiveRequiredError
+	} else {
+		nodes := cluster.Nodes()
+		values := make([]string, len(nodes))
+		valueCounts := make(map[string]int)
+		distinctValues := []string{}
+
+		for i, node := range nodes {
+			if isUp, err := node.IsUp(); err != nil {
+				return err
+			} else if !isUp {
+				continue
+			} else if value, err := node.ConfigGet(name); err != nil {
+				return err
+			} else {
+				if valueCounts[value] == 0 {
+					distinctValues = append(distinctValues, value)
+				}
+
+				values[i] = value
+				valueCounts[value] += 1
+			}
+		}
+
+		commonValue := mostCommonValue(distinctValues, valueCounts)
+
+		self.view.Echo("%-25s %-30s %s", bold("NODE"), bold("RUNNING"), bold("CONFIGURED"))
+
+		for i, node := range nodes {
+			var running string
+
+			if isUp, _ := node.IsUp(); !isUp {
+				running = yellow("DOWN")
+			} else if values[i] != commonValue {
+				running = red(fmt.Sprintf("%q", values[i]))
+			} else {
+				running = fmt.Sprintf("%q", values[i])
+			}
+
+			configured := "-"
+
+			if value, ok := node.ConfDirective(name); ok {
+				configured = fmt.Sprintf("%q", value)
+			}
+
+			self.view.Echo("%-25s %-30s %s", node.Address(), running, configured)
+		}
+
+		if len(valueCounts) > 1 {
+			self.view.Echo("%s Value of %s differs across nodes", yellow("DRIFT"), bold(name))
+		}
+
+		return nil
+	}
+}
+
+// Selects nodes by port and role. Zero port and empty role match any node
+func (self *Controller) selectNodes(cluster *Cluster, port int, role string) ([]*Node, error) {
+	if role == "replica" {
+		role = RoleSlave
+	}
+
+	if len(role) > 0 && role != RoleMaster && role != RoleSlave {
+		return nil, IllegalRoleError(role)
+	}
+
+	var nodes []*Node
+
+	if port > 0 {
+		if node, ok := cluster.NodeByPort(port); !ok {
+			return nil, NodeDoesNotExistError(port)
+		} else {
+			nodes = []*Node{node}
+		}
+	} else {
+		nodes = cluster.Nodes()
+	}
+
+	if len(role) < 1 {
+		return nodes, nil
+	}
+
+	result := nodes[:0]
+
+	for _, node := range nodes {
+		if isUp, err := node.IsUp(); err != nil {
+			return nil, err
+		} else if !isUp {
+			self.view.Echo("Node %s is %s. Can't determine its role, skipping", node.Address(), yellow("DOWN"))
+		} else if nodeRole, err := node.Role(); err != nil {
+			return nil, err
+		} else if nodeRole == role {
+			result = append(result, node)
+		}
+	}
+
+	if len(result) < 1 {
+		return nil, NoNodesSelectedError
+	}
+
+	return result, nil
+}
+
 func determineDesiredUpNodeCount(clusterSize int, desiredCountDesc string) (int, error) {
 	desiredCountDesc = strings.TrimSpace(desiredCountDesc)
 
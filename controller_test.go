@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestAllocatePorts(t *testing.T) {
+	cases := []struct {
+		clusterPorts []int
+		usedPorts    []int
+		count        int
+		expected     []int
+	}{
+		{[]int{9001, 9002, 9003}, []int{9001, 9002, 9003}, 2, []int{9004, 9005}},
+		{[]int{9001, 9002, 9003}, []int{9001, 9002, 9003, 9004, 9006}, 2, []int{9005, 9007}},
+		{[]int{9003, 9001, 9002}, []int{}, 1, []int{9004}},
+	}
+
+	for _, c := range cases {
+		ports, err := allocatePorts(c.clusterPorts, c.usedPorts, c.count)
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		} else if !reflect.DeepEqual(ports, c.expected) {
+			t.Errorf("Expected %v but got %v", c.expected, ports)
+		}
+	}
+
+	maxPort := MaxTcpPort - RedisGossipPortIncrement
+
+	if _, err := allocatePorts([]int{maxPort - 1, maxPort}, []int{}, 1); err == nil {
+		t.Errorf("Expected allocation beyond max port to fail")
+	}
+}
+
+func TestCollectRedisDirectives(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_controller_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	fname := tmpdir + "/base.conf"
+	content := "port 6379\ndir /var/lib/redis\nmaxmemory 100mb\nrename-command FLUSHALL X\nrename-command CONFIG Y\n" +
+		"client-output-buffer-limit normal 0 0 0\nclient-output-buffer-limit pubsub 32mb 8mb 60\n"
+
+	if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	directives, repeated, skipped, err := collectRedisDirectives(fname, []string{"maxmemory=200mb", "rename-command=DEBUG Z"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(skipped, []string{"port", "dir"}) {
+		t.Errorf("Expected managed directives to be skipped but got %v", skipped)
+	}
+
+	expected := map[string]string{"maxmemory": "200mb"}
+
+	if !reflect.DeepEqual(directives, expected) {
+		t.Errorf("Expected %v but got %v", expected, directives)
+	}
+
+	expectedRepeated := map[string][]string{
+		"rename-command":             {"FLUSHALL X", "CONFIG Y", "DEBUG Z"},
+		"client-output-buffer-limit": {"normal 0 0 0", "pubsub 32mb 8mb 60"},
+	}
+
+	if !reflect.DeepEqual(repeated, expectedRepeated) {
+		t.Errorf("Expected %v but got %v", expectedRepeated, repeated)
+	}
+
+	if _, _, _, err := collectRedisDirectives("", []string{"port=7000"}); err == nil {
+		t.Errorf("Expected explicit managed directive to be rejected")
+	}
+}
+
+func TestMostCommonValue(t *testing.T) {
+	cases := []struct {
+		values   []string
+		counts   map[string]int
+		expected string
+	}{
+		{[]string{"a", "b"}, map[string]int{"a": 1, "b": 2}, "b"},
+		{[]string{"b", "a"}, map[string]int{"a": 2, "b": 2}, "b"},
+		{[]string{"a", "b"}, map[string]int{"a": 2, "b": 2}, "a"},
+		{[]string{}, map[string]int{}, ""},
+	}
+
+	for _, c := range cases {
+		if value := mostCommonValue(c.values, c.counts); value != c.expected {
+			t.Errorf("Expected %q for %v but got %q", c.expected, c.values, value)
+		}
+	}
+}
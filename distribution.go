@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var NoMastersError = errors.New("Slot distribution should have at least one master")
+
+func IllegalReplicasError(replicas int, nodesCount int) error {
+	return fmt.Errorf("Illegal number of replicas %v. Should be in range 0..%v", replicas, nodesCount-1)
+}
+
+func IllegalReplicaCountError(replicaCount string) error {
+	return fmt.Errorf("Illegal replica count '%s'. Should be in form <master port>=<replicas>", replicaCount)
+}
+
+func DuplicateMasterError(port int) error {
+	return fmt.Errorf("Node %v is specified as master more than once", port)
+}
+
+func NotEnoughReplicasError(required int, available int) error {
+	return fmt.Errorf("%v replicas are required but only %v nodes are available", required, available)
+}
+
+func UnassignedNodesError(count int) error {
+	return fmt.Errorf("%v nodes are left neither masters nor replicas", count)
+}
+
+func NodeIsNotDistributedMasterError(port int) error {
+	return fmt.Errorf("Node %v is not a master of the distribution", port)
+}
+
+// Describes how slots and replicas are distributed across nodes. All nodes are referred by their ports
+//
+// Masters are either listed explicitly, taken from replica counts or chosen as first nodes of the cluster so that
+// each master gets the specified number of replicas. Remaining nodes are assigned round-robin to masters serving
+// slots which have no explicit replica count
+type SlotDistributionSpec struct {
+	Replicas      int             `yaml:"replicas"`
+	Masters       []int           `yaml:"masters,omitempty"`
+	ReplicaCounts map[int]int     `yaml:"replica-counts,omitempty"`
+	Weights       map[int]float64 `yaml:"weights,omitempty"`
+	EmptyMasters  []int           `yaml:"empty-masters,omitempty"`
+	AntiAffinity  bool            `yaml:"anti-affinity"`
+}
+
+func DefaultSlotDistributionSpec() SlotDistributionSpec {
+	return SlotDistributionSpec{
+		Replicas:      1,
+		ReplicaCounts: make(map[int]int),
+		Weights:       make(map[int]float64),
+		AntiAffinity:  true,
+	}
+}
+
+// Loads the spec from YAML file. Missing fields keep their default values
+func LoadSlotDistributionSpec(fileName string) (SlotDistributionSpec, error) {
+	result := DefaultSlotDistributionSpec()
+	bytes, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return result, err
+	}
+
+	if err := yaml.Unmarshal(bytes, &result); err != nil {
+		return result, err
+	}
+
+	if result.ReplicaCounts == nil {
+		result.ReplicaCounts = make(map[int]int)
+	}
+
+	if result.Weights == nil {
+		result.Weights = make(map[int]float64)
+	}
+
+	return result, nil
+}
+
+// Parses replica count in form <master port>=<replicas>
+func ParseReplicaCount(replicaCount string) (int, int, error) {
+	parts := strings.SplitN(replicaCount, "=", 2)
+
+	if len(parts) != 2 {
+		return 0, 0, IllegalReplicaCountError(replicaCount)
+	}
+
+	port, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+
+	if err != nil {
+		return 0, 0, IllegalReplicaCountError(replicaCount)
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+
+	if err != nil || count < 0 {
+		return 0, 0, IllegalReplicaCountError(replicaCount)
+	}
+
+	return port, count, nil
+}
+
+// Splits total into integer shares proportional to weights. Units left by rounding are given to the shares with the
+// largest fractional parts
+func proportionalShares(total int, weights []float64) ([]int, error) {
+	totalWeight := 0.0
+
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+
+	if math.IsNaN(totalWeight) || math.IsInf(totalWeight, 0) {
+		return nil, TotalWeightTooLargeError
+	} else if totalWeight <= 0 {
+		return nil, NoWeightedMastersError
+	}
+
+	result := make([]int, len(weights))
+	fractions := make([]float64, len(weights))
+	assigned := 0
+
+	for i, weight := range weights {
+		exact := float64(total) * (weight / totalWeight)
+		result[i] = int(exact)
+		fractions[i] = exact - float64(result[i])
+		assigned += result[i]
+	}
+
+	order := make([]int, len(weights))
+
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return fractions[order[a]] > fractions[order[b]]
+	})
+
+	for assigned < total {
+		progress := false
+
+		for _, index := range order {
+			if assigned < total && isLegalWeight(weights[index]) && weights[index] > 0 {
+				result[index] += 1
+				assigned += 1
+				progress = true
+			}
+		}
+
+		if !progress {
+			return nil, NoWeightedMastersError
+		}
+	}
+
+	return result, nil
+}
+
+// Returns ports of masters in order they receive slots
+func (self SlotDistributionSpec) masterPorts(ports []int) ([]int, error) {
+	result := []int{}
+
+	if len(self.Masters) > 0 {
+		result = append(result, self.Masters...)
+	} else if len(self.ReplicaCounts) > 0 {
+		for port, _ := range self.ReplicaCounts {
+			if !containsPort(self.EmptyMasters, port) {
+				result = append(result, port)
+			}
+		}
+
+		sort.Ints(result)
+	} else {
+		available := []int{}
+
+		for _, port := range ports {
+			if !containsPort(self.EmptyMasters, port) {
+				available = append(available, port)
+			}
+		}
+
+		if self.Replicas < 0 || self.Replicas >= len(available) {
+			return nil, IllegalReplicasError(self.Replicas, len(available))
+		}
+
+		result = append(result, available[:len(available)/(self.Replicas+1)]...)
+	}
+
+	for _, port := range self.EmptyMasters {
+		if !containsPort(result, port) {
+			result = append(result, port)
+		}
+	}
+
+	if len(result) < 1 {
+		return nil, NoMastersError
+	}
+
+	for i, port := range result {
+		if !containsPort(ports, port) {
+			return nil, NodeDoesNotExistError(port)
+		} else if containsPort(result[:i], port) {
+			return nil, DuplicateMasterError(port)
+		}
+	}
+
+	for port, _ := range self.ReplicaCounts {
+		if !containsPort(result, port) {
+			return nil, NodeIsNotDistributedMasterError(port)
+		}
+	}
+
+	for port, weight := range self.Weights {
+		if !containsPort(result, port) {
+			return nil, NodeIsNotDistributedMasterError(port)
+		} else if !isLegalWeight(weight) {
+			return nil, IllegalNodeWeightError(fmt.Sprintf("%v=%v", port, weight))
+		}
+	}
+
+	return result, nil
+}
+
+// Returns the copy of the spec referring nodes by the ports they are moved to
+func (self SlotDistributionSpec) withPorts(newPort func(int) int) SlotDistributionSpec {
+	result := self
+	result.Masters = nil
+	result.EmptyMasters = nil
+	result.ReplicaCounts = make(map[int]int, len(self.ReplicaCounts))
+	result.Weights = make(map[int]float64, len(self.Weights))
+
+	for _, port := range self.Masters {
+		result.Masters = append(result.Masters, newPort(port))
+	}
+
+	for _, port := range self.EmptyMasters {
+		result.EmptyMasters = append(result.EmptyMasters, newPort(port))
+	}
+
+	for port, count := range self.ReplicaCounts {
+		result.ReplicaCounts[newPort(port)] = count
+	}
+
+	for port, weight := range self.Weights {
+		result.Weights[newPort(port)] = weight
+	}
+
+	return result
+}
+
+func (self SlotDistributionSpec) weightOf(port int) float64 {
+	if containsPort(self.EmptyMasters, port) {
+		return 0
+	} else if weight, ok := self.Weights[port]; ok {
+		return weight
+	}
+
+	return 1
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+var NoUpMastersError = errors.New("There are no masters up to serve uncovered slots")
+
+// Slot left in migrating or importing state
+type OpenSlot struct {
+	Slot     int
+	SourceId string
+	TargetId string
+}
+
+// Slots to be assigned to the master
+type SlotAssignment struct {
+	Node  *Node
+	Slots []int
+}
+
+// Repair actions for the broken cluster. Actions are applied in order of the fields
+type ClusterFix struct {
+	Forget      []ClusterNodeInfo
+	Meet        []*Node
+	OpenSlots   []OpenSlot
+	Assignments []SlotAssignment
+	seed        *Node
+	upNodes     []*Node
+	nodesById   map[string]*Node
+}
+
+func (self *ClusterFix) Empty() bool {
+	return len(self.Forget) < 1 && len(self.Meet) < 1 && len(self.OpenSlots) < 1 && len(self.Assignments) < 1
+}
+
+// Inspects nodes which are up and detects failed nodes lingering in the node tables, nodes which don't know each
+// other, slots stuck in migrating or importing state and slots served by nobody
+func (self *Cluster) PrepareFix() (*ClusterFix, error) {
+	nodes, splitIndex, err := self.NodesByState()
+
+	if err != nil {
+		return nil, err
+	} else if splitIndex < 1 {
+		return nil, ClusterIsDownError
+	}
+
+	result := &ClusterFix{
+		seed:      nodes[0],
+		upNodes:   nodes[:splitIndex],
+		nodesById: make(map[string]*Node),
+	}
+
+	topologies := make([]Topology, len(result.upNodes))
+	myselves := make([]ClusterNodeInfo, len(result.upNodes))
+
+	for i, node := range result.upNodes {
+		if topologies[i], err = node.ClusterTopology(); err != nil {
+			return nil, err
+		}
+
+		myself, ok := topologies[i].Myself()
+
+		if !ok {
+			return nil, NodeIsNotKnownError(node.Address())
+		}
+
+		myselves[i] = myself
+		result.nodesById[myself.Id] = node
+	}
+
+	result.Forget = self.staleNodesOf(topologies, result.upNodes, result.nodesById)
+
+	for i, topology := range topologies {
+		for j, other := range myselves {
+			if _, ok := topology.ById(other.Id); ok || i == j {
+				continue
+			}
+
+			for _, node := range []*Node{result.upNodes[i], result.upNodes[j]} {
+				if node != result.seed && !containsNode(result.Meet, node) {
+					result.Meet = append(result.Meet, node)
+				}
+			}
+		}
+	}
+
+	result.OpenSlots = openSlotsOf(myselves)
+
+	uncovered := uncoveredSlotsOf(topologies[0], myselves, result.OpenSlots)
+
+	if result.Assignments, err = self.assignmentsOf(uncovered, myselves, result.nodesById); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Returns failed entries which either don't belong to the cluster, belong to the node which is running under
+// another id now or are masters nobody replicates any more. Slots of the latter are taken over by other masters
+func (self *Cluster) staleNodesOf(topologies []Topology, upNodes []*Node, nodesById map[string]*Node) []ClusterNodeInfo {
+	result := []ClusterNodeInfo{}
+	seen := make(map[string]bool)
+
+	hasUpReplicas := func(info ClusterNodeInfo) bool {
+		for _, topology := range topologies {
+			for _, slave := range topology.SlavesOf(info.Id) {
+				if _, isUp := nodesById[slave.Id]; isUp {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	for _, topology := range topologies {
+		for _, info := range topology {
+			if _, isUp := nodesById[info.Id]; isUp || !info.IsFailed() || seen[info.Id] {
+				continue
+			}
+
+			node, isClusterNode := self.NodeOf(info)
+
+			if !isClusterNode || containsNode(upNodes, node) || info.HasFlag(NodeFlagNoAddr) ||
+				info.IsMaster() && !hasUpReplicas(info) {
+				seen[info.Id] = true
+				result = append(result, info)
+			}
+		}
+	}
+
+	return result
+}
+
+// Assigns each uncovered slot to the master holding most of its keys or to the master serving fewest slots
+func (self *Cluster) assignmentsOf(uncovered []int, myselves []ClusterNodeInfo, nodesById map[string]*Node) ([]SlotAssignment, error) {
+	result := []SlotAssignment{}
+
+	if len(uncovered) < 1 {
+		return result, nil
+	}
+
+	masters := []ClusterNodeInfo{}
+	slotCounts := make(map[string]int)
+	withKeys := []ClusterNodeInfo{}
+
+	for _, myself := range myselves {
+		if !myself.IsMaster() {
+			continue
+		}
+
+		masters = append(masters, myself)
+		slotCounts[myself.Id] = myself.SlotCount()
+
+		if size, err := nodesById[myself.Id].DbSize(); err != nil {
+			return nil, err
+		} else if size > 0 {
+			withKeys = append(withKeys, myself)
+		}
+	}
+
+	if len(masters) < 1 {
+		return nil, NoUpMastersError
+	}
+
+	slotsById := make(map[string][]int)
+
+	for _, slot := range uncovered {
+		targetId := ""
+		maxKeys := 0
+
+		for _, master := range withKeys {
+			if count, err := nodesById[master.Id].ClusterCountKeysInSlot(slot); err != nil {
+				return nil, err
+			} else if count > maxKeys {
+				targetId = master.Id
+				maxKeys = count
+			}
+		}
+
+		if len(targetId) < 1 {
+			for _, master := range masters {
+				if len(targetId) < 1 || slotCounts[master.Id] < slotCounts[targetId] {
+					targetId = master.Id
+				}
+			}
+		}
+
+		slotCounts[targetId] += 1
+		slotsById[targetId] = append(slotsById[targetId], slot)
+	}
+
+	for _, master := range masters {
+		if slots, ok := slotsById[master.Id]; ok {
+			result = append(result, SlotAssignment{Node: nodesById[master.Id], Slots: slots})
+		}
+	}
+
+	return result, nil
+}
+
+func (self *Cluster) ApplyFix(fix *ClusterFix, options MigrationOptions) error {
+	for _, info := range fix.Forget {
+		for _, node := range fix.upNodes {
+			if err := node.ClusterForget(info.Id); err != nil && !isUnknownNodeError(err) {
+				return err
+			}
+		}
+	}
+
+	for _, node := range fix.Meet {
+		if err := fix.seed.ClusterMeet(node.Address()); err != nil {
+			return err
+		}
+	}
+
+	if len(fix.Meet) > 0 {
+		if err := self.WaitUntilNodesKnown(fix.upNodes); err != nil {
+			return err
+		}
+	}
+
+	for _, openSlot := range fix.OpenSlots {
+		source, hasSource := fix.nodesById[openSlot.SourceId]
+		target, hasTarget := fix.nodesById[openSlot.TargetId]
+
+		if hasSource && hasTarget {
+			migration, err := self.NewSlotMigration(source, target, options)
+
+			if err != nil {
+				return err
+			}
+
+			if _, err := migration.ResumeSlot(openSlot.Slot); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// The other side of the migration is gone so the slot can only be returned to the stable state
+		for _, node := range []*Node{source, target} {
+			if node == nil {
+				continue
+			}
+
+			if err := node.ClusterSetSlot(openSlot.Slot, "STABLE", ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, assignment := range fix.Assignments {
+		if err := takeOverSlots(assignment, fix.upNodes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Takes slots over the way redis-cli --cluster fix does. Slots still mapped to failed masters are unassigned on every
+// node first, otherwise the new owner is refused since the slot is busy. The epoch is bumped so the new owner wins
+// over the failed master if it comes back
+func takeOverSlots(assignment SlotAssignment, upNodes []*Node) error {
+	for _, node := range upNodes {
+		topology, err := node.ClusterTopology()
+
+		if err != nil {
+			return err
+		}
+
+		if busy := assignedSlotsOf(topology, assignment.Slots); len(busy) > 0 {
+			if err := node.ClusterDelSlots(busy); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, slotRange := range SlotRangesOf(assignment.Slots) {
+		if err := assignment.Node.ClusterAddSlots(slotRange.From, slotRange.To+1); err != nil {
+			return err
+		}
+	}
+
+	return assignment.Node.ClusterBumpEpoch()
+}
+
+// Returns slots which are assigned to any node of the topology
+func assignedSlotsOf(topology Topology, slots []int) []int {
+	result := []int{}
+
+	for _, slot := range slots {
+		for _, info := range topology {
+			if containsSlot(info.Slots, slot) {
+				result = append(result, slot)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// Collects slots in migrating or importing state as the nodes report them about themselves
+func openSlotsOf(myselves []ClusterNodeInfo) []OpenSlot {
+	result := []OpenSlot{}
+
+	add := func(openSlot OpenSlot) {
+		for _, existing := range result {
+			if existing == openSlot {
+				return
+			}
+		}
+
+		result = append(result, openSlot)
+	}
+
+	for _, myself := range myselves {
+		for slot, targetId := range myself.Migrating {
+			add(OpenSlot{Slot: slot, SourceId: myself.Id, TargetId: targetId})
+		}
+
+		for slot, sourceId := range myself.Importing {
+			add(OpenSlot{Slot: slot, SourceId: sourceId, TargetId: myself.Id})
+		}
+	}
+
+	sort.SliceStable(result, func(a, b int) bool { return result[a].Slot < result[b].Slot })
+	return result
+}
+
+// Returns slots which are served neither in the view of the seed nor by any of the masters themselves. Slots of
+// failed masters are not served by anybody. Open slots are excluded since they are repaired separately
+func uncoveredSlotsOf(seedTopology Topology, myselves []ClusterNodeInfo, openSlots []OpenSlot) []int {
+	covered := make([]bool, RedisSlotCount)
+
+	for _, info := range append(append([]ClusterNodeInfo{}, seedTopology...), myselves...) {
+		if !info.IsMaster() || info.IsFailed() {
+			continue
+		}
+
+		for _, slot := range SlotsOf(info.Slots) {
+			covered[slot] = true
+		}
+	}
+
+	for _, openSlot := range openSlots {
+		covered[openSlot.Slot] = true
+	}
+
+	result := []int{}
+
+	for slot, isCovered := range covered {
+		if !isCovered {
+			result = append(result, slot)
+		}
+	}
+
+	return result
+}
+
+func containsNode(nodes []*Node, node *Node) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenSlotsOf(t *testing.T) {
+	source := newTestMaster("a", SlotRange{0, 100})
+	source.Migrating = map[int]string{100: "b"}
+
+	target := newTestMaster("b")
+	target.Importing = map[int]string{100: "a", 5: "c"}
+
+	openSlots := openSlotsOf([]ClusterNodeInfo{source, target})
+	expected := []OpenSlot{{Slot: 5, SourceId: "c", TargetId: "b"}, {Slot: 100, SourceId: "a", TargetId: "b"}}
+
+	if !reflect.DeepEqual(openSlots, expected) {
+		t.Errorf("Expected %v but got %v", expected, openSlots)
+	}
+}
+
+func TestUncoveredSlotsOf(t *testing.T) {
+	seedTopology := Topology{
+		newTestMaster("a", SlotRange{0, 8000}),
+		newTestReplica("c", "a"),
+	}
+
+	myselves := []ClusterNodeInfo{newTestMaster("b", SlotRange{8100, 16383})}
+	openSlots := []OpenSlot{{Slot: 8001, SourceId: "a", TargetId: "b"}}
+
+	uncovered := uncoveredSlotsOf(seedTopology, myselves, openSlots)
+
+	if !reflect.DeepEqual(SlotRangesOf(uncovered), []SlotRange{{8002, 8099}}) {
+		t.Errorf("Unexpected uncovered slots %s", FormatSlotRanges(SlotRangesOf(uncovered)))
+	}
+}
+
+func TestUncoveredSlotsOfFailedMaster(t *testing.T) {
+	failed := newTestMaster("a", SlotRange{0, 8191})
+	failed.Flags = append(failed.Flags, NodeFlagFail)
+
+	noAddr := newTestMaster("c", SlotRange{16000, 16383})
+	noAddr.Flags = append(noAddr.Flags, NodeFlagNoAddr)
+
+	seedTopology := Topology{failed, newTestMaster("b", SlotRange{8192, 15999}), noAddr}
+	myselves := []ClusterNodeInfo{newTestMaster("b", SlotRange{8192, 15999})}
+
+	uncovered := uncoveredSlotsOf(seedTopology, myselves, []OpenSlot{})
+
+	if !reflect.DeepEqual(SlotRangesOf(uncovered), []SlotRange{{0, 8191}, {16000, 16383}}) {
+		t.Errorf("Expected slots of failed masters to be uncovered but got %s", FormatSlotRanges(SlotRangesOf(uncovered)))
+	}
+}
+
+func TestStaleNodesOfFailedMaster(t *testing.T) {
+	cluster := newTestCluster(4, 1)
+
+	failed := newTestSpecNode(newTestMaster("c", SlotRange{0, 5460}), 9003)
+	failed.Flags = append(failed.Flags, NodeFlagFail)
+
+	replicated := newTestSpecNode(newTestMaster("d", SlotRange{5461, 10922}), 9004)
+	replicated.Flags = append(replicated.Flags, NodeFlagFail)
+
+	topology := Topology{
+		newTestSpecNode(newTestMaster("a", SlotRange{10923, 16383}), 9001),
+		newTestSpecNode(newTestReplica("b", "d"), 9002),
+		failed,
+		replicated,
+	}
+
+	upNodes := []*Node{cluster.nodes[0], cluster.nodes[1]}
+	nodesById := map[string]*Node{"a": cluster.nodes[0], "b": cluster.nodes[1]}
+
+	stale := cluster.staleNodesOf([]Topology{topology}, upNodes, nodesById)
+
+	if len(stale) != 1 || stale[0].Id != "c" {
+		t.Errorf("Expected only failed master without replicas to be forgotten but got %v", stale)
+	}
+}
+
+func TestApplyFixTakesOverSlotsOfFailedMaster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcm-fix")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	nodes := "a 127.0.0.1:9001@19001 myself,master - 0 0 1 connected 5461-10922\n" +
+		"b 127.0.0.1:9002@19002 master - 0 0 2 connected 10923-16383\n" +
+		"c 127.0.0.1:9003@19003 master,fail - 0 0 3 disconnected 0-5460\n"
+
+	cluster := newTestFakeCliCluster(t, dir, 3, nodes)
+	upNodes := []*Node{cluster.nodes[0], cluster.nodes[1]}
+
+	fix := &ClusterFix{
+		Assignments: []SlotAssignment{{Node: cluster.nodes[0], Slots: []int{0, 1, 2}}},
+		seed:        upNodes[0],
+		upNodes:     upNodes,
+		nodesById:   map[string]*Node{"a": upNodes[0], "b": upNodes[1]},
+	}
+
+	if err := cluster.ApplyFix(fix, MigrationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"9001 CLUSTER DELSLOTS 0 1 2",
+		"9002 CLUSTER DELSLOTS 0 1 2",
+		"9001 CLUSTER ADDSLOTSRANGE 0 2",
+		"9001 CLUSTER BUMPEPOCH",
+	}
+
+	if commands := readTestFakeCliLog(t, dir); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected commands %q but got %q", expected, commands)
+	}
+}
+
+// Supporting code
+
+// Builds the cluster talking to the fake redis-cli. Every node reports the same CLUSTER NODES output, other
+// commands succeed and are logged together with the port of the node
+func newTestFakeCliCluster(t *testing.T, dir string, nodesCount int, clusterNodes string) *Cluster {
+	script := fmt.Sprintf(`#!/bin/sh
+port=$5
+shift 5
+case "$*" in
+"CLUSTER NODES") printf '%s' ;;
+"INFO server") echo "redis_version:7.0.0" ;;
+*) echo "$port $*" >> %s; echo OK ;;
+esac
+`, clusterNodes, path.Join(dir, "commands.log"))
+
+	fileName := path.Join(dir, "redis-cli")
+
+	if err := ioutil.WriteFile(fileName, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := newTestCluster(nodesCount, 1)
+	return NewCluster(dir, cluster.Conf(), &Binaries{binaries: map[string]string{"redis-cli": fileName}})
+}
+
+func readTestFakeCliLog(t *testing.T, dir string) []string {
+	data, err := ioutil.ReadFile(path.Join(dir, "commands.log"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
@@ -0,0 +1,15 @@
+module github.com/goldobin/rcm
+
+go 1.21
+
+require (
+	github.com/codegangsta/cli v1.20.0
+	github.com/fatih/color v1.16.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	golang.org/x/sys v0.14.0 // indirect
+)
@@ -53,7 +53,6 @@ function perform-test() {
     echo "" | rcm start
     sleep 2
     echo "y" | rcm distribute-slots
-    sleep 3
 
     echo "y" | rcm damage
     sleep 1
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultMigrationPipeline int = 10
+	DefaultMigrationTimeout  int = 10000
+)
+
+// Number of keys moved by single MIGRATE command and the timeout of the command in milliseconds
+type MigrationOptions struct {
+	Pipeline int
+	Timeout  int
+	Replace  bool
+}
+
+func DefaultMigrationOptions() MigrationOptions {
+	return MigrationOptions{
+		Pipeline: DefaultMigrationPipeline,
+		Timeout:  DefaultMigrationTimeout,
+	}
+}
+
+// Moves slots with their keys between two masters following IMPORTING/MIGRATING protocol
+type SlotMigration struct {
+	source   *Node
+	target   *Node
+	sourceId string
+	targetId string
+	masters  []*Node
+	options  MigrationOptions
+}
+
+func (self *Cluster) NewSlotMigration(source *Node, target *Node, options MigrationOptions) (*SlotMigration, error) {
+	topology, err := source.ClusterTopology()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sourceInfo, ok := topology.Myself()
+
+	if !ok {
+		return nil, NodeIsNotKnownError(source.Address())
+	}
+
+	targetInfo, ok := topology.ByPort(target.Port())
+
+	if !ok {
+		return nil, NodeIsNotKnownError(target.Address())
+	}
+
+	masters := []*Node{}
+
+	for _, info := range topology.Masters() {
+		if node, ok := self.NodeOf(info); ok && info.Id != sourceInfo.Id && info.Id != targetInfo.Id {
+			masters = append(masters, node)
+		}
+	}
+
+	return &SlotMigration{
+		source:   source,
+		target:   target,
+		sourceId: sourceInfo.Id,
+		targetId: targetInfo.Id,
+		masters:  masters,
+		options:  options,
+	}, nil
+}
+
+// Moves the slot and returns the number of moved keys
+func (self *SlotMigration) MigrateSlot(slot int) (int, error) {
+	if err := self.target.ClusterSetSlot(slot, "IMPORTING", self.sourceId); err != nil {
+		return 0, err
+	}
+
+	if err := self.source.ClusterSetSlot(slot, "MIGRATING", self.targetId); err != nil {
+		return 0, err
+	}
+
+	return self.moveKeys(slot)
+}
+
+// Step completing the interrupted migration of the slot. When the target already owns the slot only remaining keys
+// are moved, otherwise slot states are set again first
+type SlotResume struct {
+	Slot     int
+	KeysOnly bool
+}
+
+// Completes the migration of the slot which was interrupted
+func (self *SlotMigration) ResumeSlot(slot int) (int, error) {
+	topology, err := self.target.ClusterTopology()
+
+	if err != nil {
+		return 0, err
+	}
+
+	myself, _ := topology.Myself()
+
+	if resumesOf(myself, []int{slot})[0].KeysOnly {
+		return self.moveKeys(slot)
+	}
+
+	return self.MigrateSlot(slot)
+}
+
+// Returns slots left in migrating or importing state between source and target by the interrupted migration
+func (self *SlotMigration) OpenSlots() ([]int, error) {
+	sourceTopology, err := self.source.ClusterTopology()
+
+	if err != nil {
+		return nil, err
+	}
+
+	targetTopology, err := self.target.ClusterTopology()
+
+	if err != nil {
+		return nil, err
+	}
+
+	source, _ := sourceTopology.Myself()
+	target, _ := targetTopology.Myself()
+
+	return openSlotsBetween(self.sourceId, self.targetId, source, target), nil
+}
+
+// Returns slots the source reports as migrating to the target or the target reports as importing from the source.
+// Both nodes are described as they see themselves
+func openSlotsBetween(sourceId string, targetId string, source ClusterNodeInfo, target ClusterNodeInfo) []int {
+	open := make(map[int]bool)
+
+	for slot, id := range source.Migrating {
+		if id == targetId {
+			open[slot] = true
+		}
+	}
+
+	for slot, id := range target.Importing {
+		if id == sourceId {
+			open[slot] = true
+		}
+	}
+
+	result := make([]int, 0, len(open))
+
+	for slot, _ := range open {
+		result = append(result, slot)
+	}
+
+	sort.Ints(result)
+	return result
+}
+
+func resumesOf(target ClusterNodeInfo, slots []int) []SlotResume {
+	result := make([]SlotResume, len(slots))
+
+	for i, slot := range slots {
+		result[i] = SlotResume{Slot: slot, KeysOnly: containsSlot(target.Slots, slot)}
+	}
+
+	return result
+}
+
+// Moves remaining keys of the slot which is already in migrating state and assigns the slot to the target
+func (self *SlotMigration) moveKeys(slot int) (int, error) {
+	moved := 0
+
+	for {
+		keys, err := self.source.ClusterGetKeysInSlot(slot, self.options.Pipeline)
+
+		if err != nil {
+			return moved, err
+		}
+
+		if len(keys) < 1 {
+			break
+		}
+
+		if err := self.source.Migrate(self.target.Address(), keys, self.options.Timeout, self.options.Replace); err != nil {
+			return moved, err
+		}
+
+		moved += len(keys)
+	}
+
+	return moved, self.assign(slot)
+}
+
+// Assigns the slot to the target. The target is notified first so it does not redirect clients back to the source
+func (self *SlotMigration) assign(slot int) error {
+	for _, node := range append([]*Node{self.target, self.source}, self.masters...) {
+		if err := node.ClusterSetSlot(slot, "NODE", self.targetId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Splits slots into contiguous chunks between targets so that targets end up with as equal number of slots as possible
+func splitSlots(slots []int, targetSlotCounts []int) [][]int {
+	counts := append([]int{}, targetSlotCounts...)
+	quotas := make([]int, len(counts))
+
+	for range slots {
+		min := 0
+
+		for i, count := range counts {
+			if count < counts[min] {
+				min = i
+			}
+		}
+
+		counts[min] += 1
+		quotas[min] += 1
+	}
+
+	result := make([][]int, len(quotas))
+	offset := 0
+
+	for i, quota := range quotas {
+		result[i] = slots[offset : offset+quota]
+		offset += quota
+	}
+
+	return result
+}
+
+func isUnknownNodeError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Unknown node")
+}
+
+func containsSlot(ranges []SlotRange, slot int) bool {
+	for _, r := range ranges {
+		if slot >= r.From && slot <= r.To {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,23 +4,59 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
 )
 
-var ProcessNotRunningError = errors.New("Process is not running")
+const (
+	MaxUnixSocketPathLength = 103
+	AddSlotsChunkSize       = 1000
+	AddSlotsRangeMinVersion = 7
+)
+
+var (
+	ProcessNotRunningError = errors.New("Process is not running")
+
+	pipeErrorsRegEx = regexp.MustCompile(`errors: (\d+), replies: (\d+)`)
+	replyErrorRegEx = regexp.MustCompile(
+		`^(?:\(error\) )?((?:ERR|WRONGTYPE|MOVED|ASK|NOAUTH|WRONGPASS|NOPERM|LOADING|BUSY|BUSYKEY|CLUSTERDOWN|` +
+			`CROSSSLOT|TRYAGAIN|READONLY|MASTERDOWN|IOERR|NOREPLICAS|EXECABORT)\b.*)`)
+)
+
+func PipeError(errorCount string, output string) error {
+	return fmt.Errorf("%s of pipelined commands failed: %s", errorCount, strings.TrimSpace(output))
+}
+
+func UnixSocketPathTooLongError(socketPath string) error {
+	return fmt.Errorf("Unix socket path %s is longer than %v characters", socketPath, MaxUnixSocketPathLength)
+}
+
+func NodeIsNotKnownError(address NodeAddress) error {
+	return fmt.Errorf("Node %s is not known to the cluster", address)
+}
+
+func UnexpectedReplyError(reply string) error {
+	return fmt.Errorf("Unexpected reply '%s'", strings.TrimSpace(reply))
+}
+
+func UnknownDirectiveError(name string) error {
+	return fmt.Errorf("Unknown directive '%s'", name)
+}
 
 type NodeAddress struct {
 	Ip   string
 	Port int
 }
 
+// Formats the address as host:port enclosing IPv6 addresses in brackets
 func (self NodeAddress) String() string {
-	return fmt.Sprintf("%s:%v", self.Ip, self.Port)
+	return net.JoinHostPort(self.Ip, strconv.Itoa(self.Port))
 }
 
 func NewNodeAddress(ip string, port int) NodeAddress {
@@ -32,8 +68,11 @@ func NewNodeAddress(ip string, port int) NodeAddress {
 
 type Node struct {
 	address      NodeAddress
+	baseDir      string
 	confFilePath string
 	conf         RedisNodeConf
+	tlsCa        TlsFiles
+	tlsClient    TlsFiles
 	binaries     *Binaries
 }
 
@@ -41,23 +80,59 @@ func NewNode(clusterBaseDir string, port int, clusterConf *ClusterConf, binaries
 
 	baseDir := path.Join(clusterBaseDir, strconv.Itoa(port))
 
+	var aclFile string
+
+	if len(clusterConf.Users) > 0 {
+		aclFile = path.Join(baseDir, "conf", "users.acl")
+	}
+
+	var unixSocket string
+
+	if clusterConf.UnixSocket {
+		unixSocket = path.Join(baseDir, "var", "run", "redis.sock")
+	}
+
+	var tlsCa, tlsClient, tls TlsFiles
+
+	if clusterConf.Tls {
+		tlsCa = ClusterTlsCa(clusterBaseDir)
+		tlsClient = ClusterTlsClient(clusterBaseDir)
+		tls = NewTlsFiles(path.Join(baseDir, TlsDirName), TlsCertFileName, TlsKeyFileName)
+	}
+
 	return &Node{
-		address:      NewNodeAddress(clusterConf.ListenIp, port),
+		address:      NewNodeAddress(clusterConf.NodePrimaryIp(port), port),
+		baseDir:      baseDir,
 		confFilePath: path.Join(baseDir, "conf", "redis.conf"),
 		conf: RedisNodeConf{
-			ListenIp:    clusterConf.ListenIp,
+			ListenIp:    clusterConf.NodeListenIp(port),
 			ListenPort:  port,
 			Persistence: clusterConf.Persistence,
 			LogFile:     path.Join(baseDir, "var", "log", "redis.log"),
 			PidFile:     path.Join(baseDir, "var", "run", "redis.pid"),
 			DataDir:     path.Join(baseDir, "var", "lib", "redis"),
+			Password:    clusterConf.Password,
+			AclFile:     aclFile,
+			Users:       clusterConf.Users,
+			TlsCaFile:   tlsCa.CertFile,
+			TlsCertFile: tls.CertFile,
+			TlsKeyFile:  tls.KeyFile,
+			UnixSocket:  unixSocket,
+			Directives:  clusterConf.NodeRedisDirectives(port),
+			Repeated:    clusterConf.NodeRepeatedRedisDirectives(port),
 		},
-		binaries: binaries,
+		tlsCa:     tlsCa,
+		tlsClient: tlsClient,
+		binaries:  binaries,
 	}
 }
 
 func (self *Node) Create() error {
 
+	if len(self.conf.UnixSocket) > MaxUnixSocketPathLength {
+		return UnixSocketPathTooLongError(self.conf.UnixSocket)
+	}
+
 	if err := os.MkdirAll(path.Dir(self.confFilePath), 0750); err != nil {
 		return err
 	}
@@ -74,10 +149,89 @@ func (self *Node) Create() error {
 		return err
 	}
 
+	if self.tlsCa.Enabled() {
+		files := TlsFiles{CertFile: self.conf.TlsCertFile, KeyFile: self.conf.TlsKeyFile}
+
+		if err := GenerateTlsCert(self.tlsCa, files, self.address.String(), tlsHosts(strings.Fields(self.conf.ListenIp)...)); err != nil {
+			return err
+		}
+	}
+
+	return self.SaveConf()
+}
+
+func (self *Node) SaveConf() error {
+	if len(self.conf.AclFile) > 0 {
+		channelRules := self.binaries.AclChannelsSupported()
+
+		if err := SaveAclFile(self.conf.AclFile, self.conf.Password, self.conf.Users, channelRules); err != nil {
+			return err
+		}
+	}
+
 	return SaveRedisConf(self.confFilePath, &self.conf)
 }
 
+// Returns the value of the directive as it written to the node's redis.conf
+func (self *Node) ConfDirective(name string) (string, bool) {
+	if value, ok := self.conf.Directives[name]; ok {
+		return value, true
+	} else if values, ok := self.conf.Repeated[name]; ok {
+		return strings.Join(values, ", "), true
+	}
+
+	return "", false
+}
+
+// Tells whether directories of the node have been created
+func (self *Node) Exists() bool {
+	_, err := os.Stat(self.baseDir)
+	return err == nil
+}
+
+// Removes all the files of the node. The node should be stopped
+func (self *Node) Remove() error {
+	return os.RemoveAll(self.baseDir)
+}
+
+// Copies data and logs of the stopped node to another node. Configuration of the target is regenerated and addresses
+// in its nodes.conf are rewritten according to the port map
+func (self *Node) CopyTo(target *Node, portMap map[int]int) error {
+	if err := copyDir(self.baseDir, target.baseDir); err != nil {
+		return err
+	}
+
+	if err := os.Remove(target.conf.PidFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := target.SaveConf(); err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(target.NodesConfFile())
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(target.NodesConfFile(), []byte(RewriteNodesConf(string(content), portMap)), 0644)
+}
+
+// Cluster state file. Redis resolves it relative to the data directory
+func (self *Node) NodesConfFile() string {
+	return path.Join(self.conf.DataDir, "nodes.conf")
+}
+
+// Regenerates the configuration before starting the node so it refers to the actual location of the cluster even
+// after the cluster directory was moved
 func (self *Node) Start() error {
+	if err := self.SaveConf(); err != nil {
+		return err
+	}
+
 	binary := self.binaries.RedisServer()
 	return exec.Command(binary, self.confFilePath).Run()
 }
@@ -107,45 +261,365 @@ func (self *Node) KillWithSignal(signal string) error {
 }
 
 func (self *Node) clientArgs(args []string) []string {
-	return append(
-		[]string{
-			"-c",
-			"-h", self.conf.ListenIp,
-			"-p", strconv.Itoa(self.conf.ListenPort),
-		},
-		args...)
+	result := []string{
+		"-c",
+		"-h", self.address.Ip,
+		"-p", strconv.Itoa(self.conf.ListenPort),
+	}
+
+	if self.tlsClient.Enabled() {
+		result = append(
+			result,
+			"--tls",
+			"--cacert", self.tlsCa.CertFile,
+			"--cert", self.tlsClient.CertFile,
+			"--key", self.tlsClient.KeyFile)
+	}
+
+	return append(result, args...)
+}
+
+// Passes the password through the environment so it is neither visible in process list nor warned about
+func (self *Node) clientEnv() []string {
+	env := os.Environ()
+
+	if len(self.conf.Password) > 0 {
+		env = append(env, "REDISCLI_AUTH="+self.conf.Password)
+	}
+
+	return env
 }
 
 func (self *Node) Cli(args ...string) error {
 	clientPath := self.binaries.RedisClient()
 	commandArgs := append([]string{clientPath}, self.clientArgs(args)...)
 
-	return syscall.Exec(clientPath, commandArgs, os.Environ())
+	return syscall.Exec(clientPath, commandArgs, self.clientEnv())
 }
 
 func (self *Node) Client(args ...string) *exec.Cmd {
 	binary := self.binaries.RedisClient()
-	return exec.Command(binary, self.clientArgs(args)...)
+	cmd := exec.Command(binary, self.clientArgs(args)...)
+	cmd.Env = self.clientEnv()
+
+	return cmd
+}
+
+// Executes the command and returns its output. Error replies of the server are also reported as errors
+func (self *Node) Output(args ...string) (string, error) {
+	b, err := self.Client(args...).Output()
+
+	if err != nil {
+		return "", err
+	}
+
+	output := string(b)
+
+	if matches := replyErrorRegEx.FindStringSubmatch(output); len(matches) > 1 {
+		return "", errors.New(strings.TrimSpace(matches[1]))
+	}
+
+	return output, nil
+}
+
+func (self *Node) Info(section string) (map[string]string, error) {
+	if output, err := self.Output("INFO", section); err != nil {
+		return nil, err
+	} else {
+		return parseInfo(output), nil
+	}
+}
+
+// Returns fields of CLUSTER INFO reply like cluster_state
+func (self *Node) ClusterInfoFields() (map[string]string, error) {
+	if output, err := self.Output("CLUSTER", "INFO"); err != nil {
+		return nil, err
+	} else {
+		return parseInfo(output), nil
+	}
+}
+
+// Parses field:value lines skipping comments
+func parseInfo(output string) map[string]string {
+	result := make(map[string]string)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+			result[parts[0]] = parts[1]
+		}
+	}
+
+	return result
+}
+
+func (self *Node) Role() (string, error) {
+	if info, err := self.Info("replication"); err != nil {
+		return "", err
+	} else if role, ok := info["role"]; !ok {
+		return "", errors.New("Can't fetch node's role")
+	} else {
+		return role, nil
+	}
+}
+
+func (self *Node) ConfigSet(name string, value string) error {
+	_, err := self.Output("CONFIG", "SET", name, value)
+	return err
+}
+
+// Applies persistence settings to the running node. AOF is switched the last so the rewrite starts with final settings
+func (self *Node) ConfigSetPersistence(persistence PersistenceConf) error {
+	if err := self.ConfigSet("save", strings.Join(persistence.SavePoints(), " ")); err != nil {
+		return err
+	}
+
+	if persistence.AofEnabled() {
+		if err := self.ConfigSet("appendfsync", persistence.Fsync()); err != nil {
+			return err
+		}
+
+		if err := self.ConfigSet("aof-use-rdb-preamble", yesNo(persistence.RdbEnabled())); err != nil {
+			return err
+		}
+	}
+
+	return self.ConfigSet("appendonly", yesNo(persistence.AofEnabled()))
+}
+
+func (self *Node) ConfigGet(name string) (string, error) {
+	output, err := self.Output("CONFIG", "GET", name)
+
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+
+	if len(lines) < 2 {
+		return "", UnknownDirectiveError(name)
+	}
+
+	return lines[1], nil
+}
+
+func (self *Node) Ping() error {
+	if output, err := self.Output("PING"); err != nil {
+		return err
+	} else if strings.TrimSpace(output) != "PONG" {
+		return UnexpectedReplyError(output)
+	}
+
+	return nil
+}
+
+func (self *Node) ClusterMeet(nodeAddress NodeAddress) error {
+	_, err := self.Output("CLUSTER", "MEET", nodeAddress.Ip, strconv.Itoa(nodeAddress.Port))
+	return err
+}
+
+func (self *Node) ClusterReplicate(id string) error {
+	_, err := self.Output("CLUSTER", "REPLICATE", id)
+	return err
+}
+
+// Starts manual failover on the replica. Option is either empty, FORCE or TAKEOVER
+func (self *Node) ClusterFailover(option string) error {
+	args := []string{"CLUSTER", "FAILOVER"}
+
+	if len(option) > 0 {
+		args = append(args, option)
+	}
+
+	_, err := self.Output(args...)
+	return err
+}
+
+func (self *Node) ClusterForget(id string) error {
+	_, err := self.Output("CLUSTER", "FORGET", id)
+	return err
+}
+
+// Changes the state of the slot. State is one of IMPORTING, MIGRATING, NODE (these require node id) or STABLE
+func (self *Node) ClusterSetSlot(slot int, state string, id string) error {
+	args := []string{"CLUSTER", "SETSLOT", strconv.Itoa(slot), state}
+
+	if len(id) > 0 {
+		args = append(args, id)
+	}
+
+	_, err := self.Output(args...)
+	return err
+}
+
+func (self *Node) ClusterGetKeysInSlot(slot int, count int) ([]string, error) {
+	output, err := self.Output("CLUSTER", "GETKEYSINSLOT", strconv.Itoa(slot), strconv.Itoa(count))
+
+	if err != nil {
+		return nil, err
+	}
+
+	output = strings.TrimRight(output, "\n")
+
+	if len(output) < 1 {
+		return []string{}, nil
+	}
+
+	return strings.Split(output, "\n"), nil
+}
+
+func (self *Node) ClusterCountKeysInSlot(slot int) (int, error) {
+	if output, err := self.Output("CLUSTER", "COUNTKEYSINSLOT", strconv.Itoa(slot)); err != nil {
+		return -1, err
+	} else {
+		return strconv.Atoi(strings.TrimSpace(output))
+	}
+}
+
+// Removes all the keys of the node
+func (self *Node) FlushAll() error {
+	_, err := self.Output("FLUSHALL")
+	return err
+}
+
+// Makes the node forget the cluster. Hard reset also gives the node a new id and resets epochs
+func (self *Node) ClusterReset(hard bool) error {
+	mode := "SOFT"
+
+	if hard {
+		mode = "HARD"
+	}
+
+	_, err := self.Output("CLUSTER", "RESET", mode)
+	return err
+}
+
+// Returns the number of keys stored by the node
+func (self *Node) DbSize() (int, error) {
+	if output, err := self.Output("DBSIZE"); err != nil {
+		return -1, err
+	} else {
+		return strconv.Atoi(strings.TrimSpace(output))
+	}
+}
+
+// Atomically moves keys to the target node. Authenticates on the target with the same password as the node uses
+func (self *Node) Migrate(target NodeAddress, keys []string, timeout int, replace bool) error {
+	args := []string{"MIGRATE", target.Ip, strconv.Itoa(target.Port), "", "0", strconv.Itoa(timeout)}
+
+	if replace {
+		args = append(args, "REPLACE")
+	}
+
+	if len(self.conf.Password) > 0 {
+		args = append(args, "AUTH", self.conf.Password)
+	}
+
+	args = append(append(args, "KEYS"), keys...)
+
+	_, err := self.Output(args...)
+	return err
+}
+
+func (self *Node) ClusterTopology() (Topology, error) {
+	if output, err := self.Output("CLUSTER", "NODES"); err != nil {
+		return nil, err
+	} else {
+		return ParseClusterNodes(output)
+	}
+}
+
+// Assigns slots [fromSlot, toSlot) to the node. Redis 7 and later accept the whole range with single ADDSLOTSRANGE
+// command. Older versions get ADDSLOTS commands of limited size pipelined through single connection
+func (self *Node) ClusterAddSlots(fromSlot int, toSlot int) error {
+	if version, err := self.RedisVersion(); err != nil {
+		return err
+	} else if versionAtLeast(version, AddSlotsRangeMinVersion) {
+		_, err := self.Output("CLUSTER", "ADDSLOTSRANGE", strconv.Itoa(fromSlot), strconv.Itoa(toSlot-1))
+		return err
+	}
+
+	commands := [][]string{}
+
+	for chunkFrom := fromSlot; chunkFrom < toSlot; chunkFrom += AddSlotsChunkSize {
+		command := []string{"CLUSTER", "ADDSLOTS"}
+
+		for slot := chunkFrom; slot < toSlot && slot < chunkFrom+AddSlotsChunkSize; slot++ {
+			command = append(command, strconv.Itoa(slot))
+		}
+
+		commands = append(commands, command)
+	}
+
+	return self.Pipe(commands)
 }
 
-func (self *Node) ClusterMeet(nodeAddress NodeAddress) *exec.Cmd {
-	return self.Client("CLUSTER", "MEET", nodeAddress.Ip, strconv.Itoa(nodeAddress.Port))
+// Marks slots as unassigned in the view of the node
+func (self *Node) ClusterDelSlots(slots []int) error {
+	args := []string{"CLUSTER", "DELSLOTS"}
+
+	for _, slot := range slots {
+		args = append(args, strconv.Itoa(slot))
+	}
+
+	_, err := self.Output(args...)
+	return err
 }
 
-func (self *Node) ClusterReplicate(id string) *exec.Cmd {
-	return self.Client("CLUSTER", "REPLICATE", id)
+// Gives the node the new config epoch unless it already has the greatest one
+func (self *Node) ClusterBumpEpoch() error {
+	_, err := self.Output("CLUSTER", "BUMPEPOCH")
+	return err
 }
 
-func (self *Node) ClusterAddSlots(fromSlot int, toSlot int) *exec.Cmd {
+// Sends commands through single connection using redis-cli pipe mode
+func (self *Node) Pipe(commands [][]string) error {
+	cmd := self.Client("--pipe")
+	cmd.Stdin = strings.NewReader(encodeResp(commands))
 
-	slots := make([]string, toSlot-fromSlot)
+	b, err := cmd.CombinedOutput()
+	output := string(b)
 
-	for i := fromSlot; i < toSlot; i++ {
-		slots[i-fromSlot] = strconv.Itoa(i)
+	if matches := pipeErrorsRegEx.FindStringSubmatch(output); len(matches) > 1 && matches[1] != "0" {
+		return PipeError(matches[1], output)
 	}
 
-	args := append([]string{"CLUSTER", "ADDSLOTS"}, slots...)
-	return self.Client(args...)
+	return err
+}
+
+func (self *Node) RedisVersion() (string, error) {
+	if info, err := self.Info("server"); err != nil {
+		return "", err
+	} else if version, ok := info["redis_version"]; !ok {
+		return "", errors.New("Can't fetch node's version")
+	} else {
+		return version, nil
+	}
+}
+
+// Encodes commands using Redis protocol as it expected by redis-cli pipe mode
+func encodeResp(commands [][]string) string {
+	result := []string{}
+
+	for _, command := range commands {
+		result = append(result, fmt.Sprintf("*%v\r\n", len(command)))
+
+		for _, arg := range command {
+			result = append(result, fmt.Sprintf("$%v\r\n%s\r\n", len(arg), arg))
+		}
+	}
+
+	return strings.Join(result, "")
+}
+
+func versionAtLeast(version string, major int) bool {
+	n, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	return err == nil && n >= major
 }
 
 func (self *Node) ClusterSlots() *exec.Cmd {
@@ -175,6 +649,20 @@ func (self *Node) Pid() (int, error) {
 	}
 }
 
+// Returns the path of the node's unix socket or empty string if the socket is not enabled
+func (self *Node) UnixSocket() string {
+	return self.conf.UnixSocket
+}
+
+// Returns the (possibly simulated) host the node is running on
+func (self *Node) Host() string {
+	return self.address.Ip
+}
+
+func (self *Node) Port() int {
+	return self.address.Port
+}
+
 func (self *Node) Address() NodeAddress {
 	return self.address
 }
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestNodeAddressString(t *testing.T) {
+	cases := []struct {
+		address  NodeAddress
+		expected string
+	}{
+		{NewNodeAddress("127.0.0.1", 9001), "127.0.0.1:9001"},
+		{NewNodeAddress("::1", 9001), "[::1]:9001"},
+		{NewNodeAddress("fd00::10:1", 7000), "[fd00::10:1]:7000"},
+	}
+
+	for _, c := range cases {
+		if s := c.address.String(); s != c.expected {
+			t.Errorf("Expected %v but got %v", c.expected, s)
+		}
+	}
+}
+
+func TestEncodeResp(t *testing.T) {
+	commands := [][]string{{"CLUSTER", "ADDSLOTS", "1", "10"}, {"PING"}}
+	expected := "*4\r\n$7\r\nCLUSTER\r\n$8\r\nADDSLOTS\r\n$1\r\n1\r\n$2\r\n10\r\n*1\r\n$4\r\nPING\r\n"
+
+	if s := encodeResp(commands); s != expected {
+		t.Errorf("Expected %q but got %q", expected, s)
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		version  string
+		major    int
+		expected bool
+	}{
+		{"7.0.0", 7, true},
+		{"7.2.4", 7, true},
+		{"10.0.1", 7, true},
+		{"6.2.14", 7, false},
+		{"", 7, false},
+	}
+
+	for _, c := range cases {
+		if result := versionAtLeast(c.version, c.major); result != c.expected {
+			t.Errorf("Expected %v for %v but got %v", c.expected, c.version, result)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+var EmptySlotPlanError = errors.New("Slot plan should have at least one shard")
+
+func NodeUsedTwiceError(port int) error {
+	return fmt.Errorf("Node %v is used more than once in the plan", port)
+}
+
+func SlotRangesOverlapError(a SlotRange, b SlotRange) error {
+	return fmt.Errorf("Slot ranges %s and %s overlap", a, b)
+}
+
+func SlotsNotCoveredError(ranges []SlotRange) error {
+	return fmt.Errorf("Slots %s are not covered by the plan", FormatSlotRanges(ranges))
+}
+
+// Shard as it stored in the plan file. Nodes are referred by their ports and slots are given as comma separated
+// inclusive ranges N-M. Masters without slots have no slot ranges
+type ShardPlan struct {
+	Master   int    `yaml:"master"`
+	Slots    string `yaml:"slots,omitempty"`
+	Replicas []int  `yaml:"replicas,omitempty"`
+}
+
+type SlotPlan struct {
+	Shards []ShardPlan `yaml:"shards"`
+}
+
+func NewSlotPlan(shards []Shard) SlotPlan {
+	result := SlotPlan{Shards: make([]ShardPlan, len(shards))}
+
+	for i, shard := range shards {
+		result.Shards[i].Master = shard.MasterAddress.Port
+
+		result.Shards[i].Slots = FormatSlotRanges(shard.Slots)
+
+		for _, slaveAddress := range shard.SlavesAddresses {
+			result.Shards[i].Replicas = append(result.Shards[i].Replicas, slaveAddress.Port)
+		}
+	}
+
+	return result
+}
+
+func LoadSlotPlan(fileName string) (SlotPlan, error) {
+	result := SlotPlan{}
+
+	if data, err := ioutil.ReadFile(fileName); err != nil {
+		return result, err
+	} else if err := yaml.Unmarshal(data, &result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func SaveSlotPlan(fileName string, plan SlotPlan) error {
+	if data, err := yaml.Marshal(&plan); err != nil {
+		return err
+	} else {
+		return ioutil.WriteFile(fileName, data, 0644)
+	}
+}
+
+// Converts the plan to shards of the cluster. The plan is rejected unless slots are fully covered without overlaps
+// and each node of the cluster is used at most once
+func (self *Cluster) ShardsOf(plan SlotPlan) ([]Shard, error) {
+	if len(plan.Shards) < 1 {
+		return nil, EmptySlotPlanError
+	}
+
+	result := make([]Shard, len(plan.Shards))
+	used := []int{}
+	ranges := []SlotRange{}
+
+	indexOf := func(port int) (int, error) {
+		node, ok := self.NodeByPort(port)
+
+		if !ok {
+			return 0, NodeDoesNotExistError(port)
+		} else if containsPort(used, port) {
+			return 0, NodeUsedTwiceError(port)
+		}
+
+		used = append(used, port)
+		return self.nodeIndex(node), nil
+	}
+
+	for i, shardPlan := range plan.Shards {
+		masterIndex, err := indexOf(shardPlan.Master)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[i].masterIndex = masterIndex
+		result[i].MasterAddress = self.nodes[masterIndex].Address()
+
+		if len(shardPlan.Slots) > 0 {
+			slotRanges, err := ParseSlotRanges(shardPlan.Slots)
+
+			if err != nil {
+				return nil, err
+			}
+
+			ranges = append(ranges, slotRanges...)
+			result[i].Slots = slotRanges
+		}
+
+		for _, port := range shardPlan.Replicas {
+			slaveIndex, err := indexOf(port)
+
+			if err != nil {
+				return nil, err
+			}
+
+			result[i].slaveIndices = append(result[i].slaveIndices, slaveIndex)
+			result[i].SlavesAddresses = append(result[i].SlavesAddresses, self.nodes[slaveIndex].Address())
+		}
+	}
+
+	sort.Slice(ranges, func(a, b int) bool { return ranges[a].From < ranges[b].From })
+
+	uncovered := []SlotRange{}
+	nextSlot := 0
+
+	for i, slotRange := range ranges {
+		if i > 0 && slotRange.From <= ranges[i-1].To {
+			return nil, SlotRangesOverlapError(ranges[i-1], slotRange)
+		} else if slotRange.From > nextSlot {
+			uncovered = append(uncovered, SlotRange{From: nextSlot, To: slotRange.From - 1})
+		}
+
+		nextSlot = slotRange.To + 1
+	}
+
+	if nextSlot < RedisSlotCount {
+		uncovered = append(uncovered, SlotRange{From: nextSlot, To: RedisSlotCount - 1})
+	}
+
+	if len(uncovered) > 0 {
+		return nil, SlotsNotCoveredError(uncovered)
+	}
+
+	return result, nil
+}
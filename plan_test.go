@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSlotPlanRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcm-plan")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	cluster := newTestCluster(7, 1)
+	shards, err := cluster.PrepareSlotDistribution(newTestSpec(1))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := path.Join(dir, "plan.yml")
+
+	if err := SaveSlotPlan(fileName, NewSlotPlan(shards)); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := LoadSlotPlan(fileName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadedShards, err := cluster.ShardsOf(plan)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loadedShards, shards) {
+		t.Errorf("Expected %v but got %v", shards, loadedShards)
+	}
+}
+
+func TestSlotPlanValidation(t *testing.T) {
+	cluster := newTestCluster(4, 1)
+
+	cases := []SlotPlan{
+		{},
+		{Shards: []ShardPlan{{Master: 9001, Slots: "0-16383"}, {Master: 9100}}},
+		{Shards: []ShardPlan{{Master: 9001, Slots: "0-16383", Replicas: []int{9001}}}},
+		{Shards: []ShardPlan{{Master: 9001, Slots: "0-8191"}, {Master: 9002, Slots: "8191-16383"}}},
+		{Shards: []ShardPlan{{Master: 9001, Slots: "0-8190"}, {Master: 9002, Slots: "8192-16383"}}},
+		{Shards: []ShardPlan{{Master: 9001, Slots: "0-16384"}}},
+		{Shards: []ShardPlan{{Master: 9001, Slots: "0-99,200-16383"}, {Master: 9002, Slots: "100-299"}}},
+		{Shards: []ShardPlan{{Master: 9001, Slots: "0-99,,200-16383"}, {Master: 9002, Slots: "100-199"}}},
+	}
+
+	for i, plan := range cases {
+		if _, err := cluster.ShardsOf(plan); err == nil {
+			t.Errorf("Expected error for case %v", i)
+		}
+	}
+
+	plan := SlotPlan{Shards: []ShardPlan{
+		{Master: 9002, Slots: "8192-16383", Replicas: []int{9004}},
+		{Master: 9001, Slots: "0-8191"},
+		{Master: 9003},
+	}}
+
+	if shards, err := cluster.ShardsOf(plan); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(shards[0].Slots, []SlotRange{{8192, 16383}}) || len(shards[2].Slots) > 0 {
+		t.Errorf("Unexpected shards %v", shards)
+	}
+
+	plan = SlotPlan{Shards: []ShardPlan{
+		{Master: 9001, Slots: "0-99,200-16383"},
+		{Master: 9002, Slots: "100-199"},
+	}}
+
+	if shards, err := cluster.ShardsOf(plan); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(shards[0].Slots, []SlotRange{{0, 99}, {200, 16383}}) {
+		t.Errorf("Expected non-contiguous slots but got %v", shards[0].Slots)
+	} else if NewSlotPlan(shards).Shards[0].Slots != plan.Shards[0].Slots {
+		t.Errorf("Expected slots %s but got %s", plan.Shards[0].Slots, NewSlotPlan(shards).Shards[0].Slots)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultRebalanceThreshold float64 = 2
+
+var (
+	NoWeightedMastersError   = errors.New("At least one master should have positive weight")
+	TotalWeightTooLargeError = errors.New("Total weight of masters is too large")
+)
+
+func IllegalNodeWeightError(weight string) error {
+	return fmt.Errorf("Illegal node weight '%s'. Should be in form <port>=<weight>", weight)
+}
+
+// Slots moved from one master to another by the rebalancing
+type SlotMove struct {
+	SourceId string
+	TargetId string
+	Slots    []int
+}
+
+// Parses node weight in form <port>=<weight>
+func ParseNodeWeight(weight string) (int, float64, error) {
+	parts := strings.SplitN(weight, "=", 2)
+
+	if len(parts) != 2 {
+		return 0, 0, IllegalNodeWeightError(weight)
+	}
+
+	port, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+
+	if err != nil {
+		return 0, 0, IllegalNodeWeightError(weight)
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+
+	if err != nil || !isLegalWeight(value) {
+		return 0, 0, IllegalNodeWeightError(weight)
+	}
+
+	return port, value, nil
+}
+
+// Weights are finite and non-negative
+func isLegalWeight(weight float64) bool {
+	return !math.IsNaN(weight) && !math.IsInf(weight, 0) && weight >= 0
+}
+
+// Computes the number of slots each master should serve in proportion to its weight. Masters without weight have
+// weight 1
+func rebalanceTargets(masters []ClusterNodeInfo, weights map[string]float64) ([]int, error) {
+	masterWeights := make([]float64, len(masters))
+	totalSlots := 0
+
+	for i, master := range masters {
+		masterWeights[i] = 1
+
+		if weight, ok := weights[master.Id]; ok {
+			masterWeights[i] = weight
+		}
+
+		totalSlots += master.SlotCount()
+	}
+
+	return proportionalShares(totalSlots, masterWeights)
+}
+
+// Computes the minimal set of slot moves evening out slots across masters according to their weights. Nothing is
+// moved when no master deviates from its target by more than threshold percents. Masters of zero weight are drained
+// completely regardless of the threshold
+func PlanRebalance(masters []ClusterNodeInfo, weights map[string]float64, threshold float64) ([]SlotMove, error) {
+	targets, err := rebalanceTargets(masters, weights)
+
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]int, len(masters))
+	exceeded := false
+
+	for i, master := range masters {
+		balances[i] = master.SlotCount() - targets[i]
+
+		if balances[i] == 0 {
+			continue
+		}
+
+		if targets[i] == 0 || float64(abs(balances[i]))*100/float64(targets[i]) > threshold {
+			exceeded = true
+		}
+	}
+
+	result := []SlotMove{}
+
+	if !exceeded {
+		return result, nil
+	}
+
+	donors := []int{}
+	receivers := []int{}
+
+	for i, balance := range balances {
+		if balance > 0 {
+			donors = append(donors, i)
+		} else if balance < 0 {
+			receivers = append(receivers, i)
+		}
+	}
+
+	// Moving the largest surpluses to the largest deficits first keeps the number of moves low
+	sort.SliceStable(donors, func(a, b int) bool { return balances[donors[a]] > balances[donors[b]] })
+	sort.SliceStable(receivers, func(a, b int) bool { return balances[receivers[a]] < balances[receivers[b]] })
+
+	available := make([][]int, len(masters))
+
+	for _, i := range donors {
+		// Slots are given away from the end of the owned ranges so that the remaining ranges stay contiguous
+		slots := SlotsOf(masters[i].Slots)
+		available[i] = slots[len(slots)-balances[i]:]
+	}
+
+	for _, receiver := range receivers {
+		for _, donor := range donors {
+			if balances[receiver] == 0 {
+				break
+			}
+
+			count := balances[donor]
+
+			if -balances[receiver] < count {
+				count = -balances[receiver]
+			}
+
+			if count < 1 {
+				continue
+			}
+
+			result = append(result, SlotMove{
+				SourceId: masters[donor].Id,
+				TargetId: masters[receiver].Id,
+				Slots:    available[donor][:count],
+			})
+
+			available[donor] = available[donor][count:]
+			balances[donor] -= count
+			balances[receiver] += count
+		}
+	}
+
+	return result, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+// Replica moved under another master
+type ReplicaMove struct {
+	ReplicaId string
+	MasterId  string
+}
+
+// Computes moves which spread replicas evenly across masters serving slots. Masters already having more replicas
+// keep the spare ones so that the number of moves is minimal. Replicas of failed or empty masters are reassigned first
+func PlanReplicaBalance(topology Topology) []ReplicaMove {
+	masters := []ClusterNodeInfo{}
+	groups := make(map[string][]ClusterNodeInfo)
+
+	for _, master := range topology.Masters() {
+		if master.SlotCount() > 0 {
+			masters = append(masters, master)
+			groups[master.Id] = []ClusterNodeInfo{}
+		}
+	}
+
+	result := []ReplicaMove{}
+
+	if len(masters) < 1 {
+		return result
+	}
+
+	free := []ClusterNodeInfo{}
+	total := 0
+
+	for _, info := range topology {
+		if !info.IsSlave() || info.IsFailed() {
+			continue
+		}
+
+		total += 1
+
+		if group, ok := groups[info.MasterId]; ok {
+			groups[info.MasterId] = append(group, info)
+		} else {
+			free = append(free, info)
+		}
+	}
+
+	sort.SliceStable(masters, func(a, b int) bool {
+		return len(groups[masters[a].Id]) > len(groups[masters[b].Id])
+	})
+
+	targets := make(map[string]int)
+
+	for i, master := range masters {
+		targets[master.Id] = total / len(masters)
+
+		if i < total%len(masters) {
+			targets[master.Id] += 1
+		}
+	}
+
+	for _, master := range masters {
+		if group := groups[master.Id]; len(group) > targets[master.Id] {
+			free = append(free, group[targets[master.Id]:]...)
+		}
+	}
+
+	for _, master := range masters {
+		for missing := targets[master.Id] - len(groups[master.Id]); missing > 0; missing-- {
+			result = append(result, ReplicaMove{ReplicaId: free[0].Id, MasterId: master.Id})
+			free = free[1:]
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanRebalanceEvensOutSlots(t *testing.T) {
+	masters := []ClusterNodeInfo{
+		newTestMaster("a", SlotRange{0, 8191}),
+		newTestMaster("b", SlotRange{8192, 16383}),
+		newTestMaster("c"),
+		newTestMaster("d"),
+	}
+
+	moves, err := PlanRebalance(masters, map[string]float64{}, DefaultRebalanceThreshold)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := applyTestMoves(masters, moves)
+
+	if !reflect.DeepEqual(counts, map[string]int{"a": 4096, "b": 4096, "c": 4096, "d": 4096}) {
+		t.Errorf("Expected slots to be evened out but got %v", counts)
+	}
+
+	moved := 0
+
+	for _, move := range moves {
+		moved += len(move.Slots)
+	}
+
+	if moved != 8192 {
+		t.Errorf("Expected minimal number of slots to be moved but %v were moved", moved)
+	}
+}
+
+func TestPlanRebalanceRespectsWeights(t *testing.T) {
+	masters := []ClusterNodeInfo{
+		newTestMaster("a", SlotRange{0, 5460}),
+		newTestMaster("b", SlotRange{5461, 10922}),
+		newTestMaster("c", SlotRange{10923, 16383}),
+	}
+
+	moves, err := PlanRebalance(masters, map[string]float64{"a": 2, "c": 0}, DefaultRebalanceThreshold)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := applyTestMoves(masters, moves)
+
+	if counts["c"] != 0 {
+		t.Errorf("Expected master of zero weight to be drained but it has %v slots", counts["c"])
+	}
+
+	if counts["a"]+counts["b"] != RedisSlotCount || counts["a"] < 2*counts["b"]-1 || counts["a"] > 2*counts["b"]+1 {
+		t.Errorf("Expected slots to be split 2:1 but got %v", counts)
+	}
+}
+
+func TestPlanRebalanceWithinThreshold(t *testing.T) {
+	masters := []ClusterNodeInfo{
+		newTestMaster("a", SlotRange{0, 8220}),
+		newTestMaster("b", SlotRange{8221, 16383}),
+	}
+
+	if moves, err := PlanRebalance(masters, map[string]float64{}, DefaultRebalanceThreshold); err != nil {
+		t.Fatal(err)
+	} else if len(moves) > 0 {
+		t.Errorf("Expected no moves within threshold but got %v", len(moves))
+	}
+
+	if _, err := PlanRebalance(masters, map[string]float64{"a": 0, "b": 0}, 0); err == nil {
+		t.Errorf("Expected error when all weights are zero")
+	}
+}
+
+func TestParseNodeWeight(t *testing.T) {
+	if port, weight, err := ParseNodeWeight("9001=1.5"); err != nil || port != 9001 || weight != 1.5 {
+		t.Errorf("Unexpected result %v %v %v", port, weight, err)
+	}
+
+	for _, illegal := range []string{"9001", "x=1", "9001=x", "9001=-1", "9001=NaN", "9001=Inf", "9001=-Inf", "9001=1e309"} {
+		if _, _, err := ParseNodeWeight(illegal); err == nil {
+			t.Errorf("Expected error for '%s'", illegal)
+		}
+	}
+}
+
+func TestProportionalSharesOfHugeWeights(t *testing.T) {
+	if shares, err := proportionalShares(RedisSlotCount, []float64{1e308, 1}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(shares, []int{RedisSlotCount, 0}) {
+		t.Errorf("Expected all slots to go to the heaviest master but got %v", shares)
+	}
+
+	if _, err := proportionalShares(RedisSlotCount, []float64{1e308, 1e308}); err == nil {
+		t.Errorf("Expected error when total weight overflows")
+	}
+}
+
+func TestPlanReplicaBalance(t *testing.T) {
+	topology := Topology{
+		newTestMaster("a", SlotRange{0, 5460}),
+		newTestMaster("b", SlotRange{5461, 10922}),
+		newTestMaster("c", SlotRange{10923, 16383}),
+		newTestMaster("d"),
+		newTestReplica("r1", "a"),
+		newTestReplica("r2", "a"),
+		newTestReplica("r3", "a"),
+		newTestReplica("r4", "d"),
+		newTestReplica("r5", "b"),
+	}
+
+	moves := PlanReplicaBalance(topology)
+	counts := map[string]int{"a": 3, "b": 1, "c": 0, "d": 1}
+
+	for _, move := range moves {
+		replica, _ := topology.ById(move.ReplicaId)
+		counts[replica.MasterId] -= 1
+		counts[move.MasterId] += 1
+	}
+
+	if !reflect.DeepEqual(counts, map[string]int{"a": 2, "b": 2, "c": 1, "d": 0}) &&
+		!reflect.DeepEqual(counts, map[string]int{"a": 2, "b": 1, "c": 2, "d": 0}) {
+		t.Errorf("Expected replicas to be spread evenly but got %v", counts)
+	}
+
+	if len(moves) != 2 {
+		t.Errorf("Expected 2 moves but got %v", len(moves))
+	}
+
+	if moves := PlanReplicaBalance(topology[:3]); len(moves) > 0 {
+		t.Errorf("Expected no moves without replicas but got %v", moves)
+	}
+}
+
+// Supporting code
+
+func newTestMaster(id string, slots ...SlotRange) ClusterNodeInfo {
+	return ClusterNodeInfo{Id: id, Flags: []string{NodeFlagMaster}, Slots: slots}
+}
+
+func applyTestMoves(masters []ClusterNodeInfo, moves []SlotMove) map[string]int {
+	result := make(map[string]int)
+
+	for _, master := range masters {
+		result[master.Id] = master.SlotCount()
+	}
+
+	for _, move := range moves {
+		result[move.SourceId] -= len(move.Slots)
+		result[move.TargetId] += len(move.Slots)
+	}
+
+	return result
+}
+
+func newTestReplica(id string, masterId string) ClusterNodeInfo {
+	return ClusterNodeInfo{Id: id, Flags: []string{NodeFlagSlave}, MasterId: masterId}
+}
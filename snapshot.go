@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SnapshotsDirName       = "snapshots"
+	SnapshotConfDirName    = "conf"
+	SnapshotDataDirName    = "data"
+	snapshotInfoTimeFormat = "2006-01-02 15:04:05"
+)
+
+var IllegalSnapshotNameError = fmt.Errorf("Illegal snapshot name. The name should match %v and can't be . or ..", clusterNameRegEx)
+
+func SnapshotExistsError(name string) error {
+	return fmt.Errorf("Snapshot %s already exists", name)
+}
+
+func SnapshotDoesNotExistError(name string) error {
+	return fmt.Errorf("Snapshot %s doesn't exist", name)
+}
+
+func AofRewriteFailedError(address NodeAddress) error {
+	return fmt.Errorf("Node %s failed to rewrite AOF", address)
+}
+
+func SnapshotDoesNotMatchError(name string) error {
+	return fmt.Errorf("Nodes of snapshot %s don't match nodes of the cluster", name)
+}
+
+type SnapshotInfo struct {
+	Name      string
+	CreatedAt time.Time
+}
+
+func (self SnapshotInfo) String() string {
+	return fmt.Sprintf("%-40s %s", self.Name, self.CreatedAt.Format(snapshotInfoTimeFormat))
+}
+
+// Makes running node to write its data and cluster state to disk. Data is saved synchronously since the node is only
+// being snapshotted. AOF is rewritten when enabled so it is complete
+func (self *Node) Persist() error {
+	if _, err := self.Output("CLUSTER", "SAVECONFIG"); err != nil {
+		return err
+	}
+
+	// SAVE is refused while background save triggered by save points is in progress
+	err := retryUntil(fmt.Sprintf("node %s to save data", self.Address()), ClusterOperationTimeout, func() error {
+		_, err := self.Output("SAVE")
+		return err
+	})
+
+	if err != nil || !self.conf.Persistence.AofEnabled() {
+		return err
+	}
+
+	err = retryUntil(fmt.Sprintf("node %s to start rewriting AOF", self.Address()), ClusterOperationTimeout, func() error {
+		_, err := self.Output("BGREWRITEAOF")
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var info map[string]string
+
+	err = waitUntil(fmt.Sprintf("node %s to rewrite AOF", self.Address()), ClusterOperationTimeout, func() (bool, error) {
+		info, err = self.Info("persistence")
+		return err == nil && info["aof_rewrite_in_progress"] == "0" && info["aof_rewrite_scheduled"] == "0", err
+	})
+
+	if err != nil {
+		return err
+	} else if info["aof_last_bgrewrite_status"] != "ok" {
+		return AofRewriteFailedError(self.Address())
+	}
+
+	return nil
+}
+
+// Copies generated configuration and data directory of the node including nodes.conf
+func (self *Node) SaveSnapshot(dir string) error {
+	if err := copyDir(path.Dir(self.confFilePath), path.Join(dir, SnapshotConfDirName)); err != nil {
+		return err
+	}
+
+	return copyDir(self.conf.DataDir, path.Join(dir, SnapshotDataDirName))
+}
+
+// Replaces configuration and data of the stopped node with the copies from the snapshot
+func (self *Node) RestoreSnapshot(dir string) error {
+	confDir := path.Dir(self.confFilePath)
+
+	for _, target := range []string{confDir, self.conf.DataDir} {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+
+	if err := copyDir(path.Join(dir, SnapshotConfDirName), confDir); err != nil {
+		return err
+	}
+
+	return copyDir(path.Join(dir, SnapshotDataDirName), self.conf.DataDir)
+}
+
+// Snapshot names are used as directory names so they should neither refer to the snapshots directory itself nor leave it
+func ValidateSnapshotName(name string) error {
+	if !clusterNameRegEx.MatchString(name) || name == "." || name == ".." || strings.Contains(name, "/") {
+		return IllegalSnapshotNameError
+	}
+
+	return nil
+}
+
+func (self *Cluster) SnapshotDir(name string) (string, error) {
+	if err := ValidateSnapshotName(name); err != nil {
+		return "", err
+	}
+
+	snapshotsDir := path.Join(self.baseDir, SnapshotsDirName)
+	result := path.Join(snapshotsDir, name)
+
+	if path.Dir(result) != snapshotsDir {
+		return "", IllegalSnapshotNameError
+	}
+
+	return result, nil
+}
+
+func (self *Cluster) SnapshotExists(name string) bool {
+	dir, err := self.SnapshotDir(name)
+
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(dir)
+	return err == nil
+}
+
+func (self *Cluster) Snapshots() ([]SnapshotInfo, error) {
+	files, err := ioutil.ReadDir(path.Join(self.baseDir, SnapshotsDirName))
+
+	if os.IsNotExist(err) {
+		return []SnapshotInfo{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := []SnapshotInfo{}
+
+	for _, file := range files {
+		if file.IsDir() {
+			result = append(result, SnapshotInfo{Name: file.Name(), CreatedAt: file.ModTime()})
+		}
+	}
+
+	sort.Slice(result, func(a, b int) bool { return result[a].CreatedAt.Before(result[b].CreatedAt) })
+	return result, nil
+}
+
+// Copies every node and the configuration of the cluster to the snapshot. Running nodes should be persisted first
+func (self *Cluster) CreateSnapshot(name string) error {
+	dir, err := self.SnapshotDir(name)
+
+	if err != nil {
+		return err
+	}
+
+	for _, node := range self.nodes {
+		if err := node.SaveSnapshot(path.Join(dir, strconv.Itoa(node.Port()))); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+	}
+
+	if err := SaveClusterConf(path.Join(dir, ClusterConfFileName), self.conf); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	return nil
+}
+
+// Returns the configuration of the cluster at the time of the snapshot. Snapshots taken by older versions have no
+// configuration and the error satisfies os.IsNotExist
+func (self *Cluster) SnapshotConf(name string) (*ClusterConf, error) {
+	if dir, err := self.SnapshotDir(name); err != nil {
+		return nil, err
+	} else {
+		return LoadClusterConf(path.Join(dir, ClusterConfFileName))
+	}
+}
+
+// Restores every node from the snapshot. Nodes should be stopped
+func (self *Cluster) RestoreSnapshot(name string) error {
+	dir, err := self.SnapshotDir(name)
+
+	if err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return err
+	}
+
+	nodeDirsCount := 0
+
+	for _, file := range files {
+		if file.IsDir() {
+			nodeDirsCount += 1
+		}
+	}
+
+	if nodeDirsCount != len(self.nodes) {
+		return SnapshotDoesNotMatchError(name)
+	}
+
+	for _, node := range self.nodes {
+		if _, err := os.Stat(path.Join(dir, strconv.Itoa(node.Port()))); err != nil {
+			return SnapshotDoesNotMatchError(name)
+		}
+	}
+
+	for _, node := range self.nodes {
+		if err := node.RestoreSnapshot(path.Join(dir, strconv.Itoa(node.Port()))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *Cluster) RemoveSnapshot(name string) error {
+	if dir, err := self.SnapshotDir(name); err != nil {
+		return err
+	} else {
+		return os.RemoveAll(dir)
+	}
+}
+
+// Copies the directory recursively preserving file modes. Unix sockets and other special files are skipped
+func copyDir(src string, dst string) error {
+	info, err := os.Stat(src)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(src)
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		srcPath := path.Join(src, file.Name())
+		dstPath := path.Join(dst, file.Name())
+
+		if file.IsDir() {
+			err = copyDir(srcPath, dstPath)
+		} else if file.Mode().IsRegular() {
+			err = copyFile(srcPath, dstPath, file.Mode().Perm())
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src string, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
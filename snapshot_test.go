@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotCreateAndRestore(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_snapshot_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	conf := &ClusterConf{ListenIp: "127.0.0.1", ListenPorts: []int{9001, 9002}}
+	cluster := NewCluster(tmpdir, conf, &Binaries{})
+
+	if err := cluster.CreateNodes(); err != nil {
+		t.Fatal(err)
+	}
+
+	node, _ := cluster.NodeByPort(9001)
+
+	if err := ioutil.WriteFile(node.NodesConfFile(), []byte("before"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cluster.CreateSnapshot("seeded"); err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshotConf, err := cluster.SnapshotConf("seeded"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(snapshotConf.ListenPorts, conf.ListenPorts) {
+		t.Errorf("Expected cluster configuration to be snapshotted but got %+v", *snapshotConf)
+	}
+
+	if err := ioutil.WriteFile(node.NodesConfFile(), []byte("after"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(node.conf.DataDir, "dump.rdb"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cluster.RestoreSnapshot("seeded"); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := ioutil.ReadFile(node.NodesConfFile()); err != nil || string(content) != "before" {
+		t.Errorf("Expected nodes.conf to be restored but got '%s' %v", content, err)
+	}
+
+	if _, err := os.Stat(path.Join(node.conf.DataDir, "dump.rdb")); !os.IsNotExist(err) {
+		t.Errorf("Expected files created after the snapshot to be removed")
+	}
+
+	if _, err := os.Stat(node.confFilePath); err != nil {
+		t.Errorf("Expected redis.conf to be restored: %v", err)
+	}
+
+	if snapshots, err := cluster.Snapshots(); err != nil || len(snapshots) != 1 || snapshots[0].Name != "seeded" {
+		t.Errorf("Unexpected snapshots %v %v", snapshots, err)
+	}
+
+	conf.ListenPorts = append(conf.ListenPorts, 9003)
+
+	if err := NewCluster(tmpdir, conf, &Binaries{}).RestoreSnapshot("seeded"); err == nil {
+		t.Errorf("Expected error restoring snapshot with different nodes")
+	}
+}
+
+func TestSnapshotNameCantLeaveSnapshotsDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_snapshot_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	cluster := NewCluster(tmpdir, &ClusterConf{ListenIp: "127.0.0.1", ListenPorts: []int{9001, 9002}}, &Binaries{})
+
+	if err := cluster.CreateNodes(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cluster.CreateSnapshot("seeded"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", ".", "..", "../9001", "a/b"} {
+		if err := ValidateSnapshotName(name); err == nil {
+			t.Errorf("Expected name '%s' to be rejected", name)
+		}
+
+		if cluster.SnapshotExists(name) {
+			t.Errorf("Expected snapshot '%s' not to exist", name)
+		}
+
+		if err := cluster.RemoveSnapshot(name); err == nil {
+			t.Errorf("Expected removal of '%s' to be rejected", name)
+		}
+	}
+
+	if !cluster.SnapshotExists("seeded") {
+		t.Errorf("Expected existing snapshot to be left intact")
+	}
+
+	if _, err := os.Stat(path.Join(tmpdir, "9001")); err != nil {
+		t.Errorf("Expected nodes to be left intact: %v", err)
+	}
+}
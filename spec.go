@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultSpecStartPort = 9001
+
+var (
+	PortsRequiredError         = errors.New("Spec should list either ports or number of nodes")
+	PortsAndNodesMismatchError = errors.New("Number of nodes doesn't match the number of ports listed in the spec")
+)
+
+func DuplicatePortError(port int) error {
+	return fmt.Errorf("Port %v is listed more than once", port)
+}
+
+// Full description of the cluster. Besides the configuration stored in cluster.yml ports can be given as number of
+// nodes and the first port and nodes can be spread across simulated hosts the same way create options do it
+type ClusterSpec struct {
+	Name      string      `yaml:"name,omitempty"`
+	Nodes     int         `yaml:"nodes,omitempty"`
+	StartPort int         `yaml:"start-port,omitempty"`
+	Hosts     int         `yaml:"hosts,omitempty"`
+	Conf      ClusterConf `yaml:",inline"`
+}
+
+// Builds the spec of the existing cluster
+func NewClusterSpec(name string, conf *ClusterConf) ClusterSpec {
+	return ClusterSpec{Name: name, Conf: *conf}
+}
+
+func LoadClusterSpec(fileName string) (ClusterSpec, error) {
+	result := ClusterSpec{}
+
+	if data, err := ioutil.ReadFile(fileName); err != nil {
+		return result, err
+	} else if err := yaml.Unmarshal(data, &result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (self ClusterSpec) Marshal() (string, error) {
+	data, err := yaml.Marshal(&self)
+	return string(data), err
+}
+
+// Validates the spec and converts it to the configuration of the cluster. Missing bind address, persistence mode and
+// distribution get the same defaults create command uses
+func (self ClusterSpec) ClusterConf() (*ClusterConf, error) {
+	result := self.Conf
+	ports := append([]int{}, result.ListenPorts...)
+
+	if len(ports) < 1 {
+		if self.Nodes < 1 {
+			return nil, PortsRequiredError
+		}
+
+		startPort := self.StartPort
+
+		if startPort < 1 {
+			startPort = DefaultSpecStartPort
+		}
+
+		for i := 0; i < self.Nodes; i++ {
+			ports = append(ports, startPort+i)
+		}
+	} else if self.Nodes > 0 && self.Nodes != len(ports) {
+		return nil, PortsAndNodesMismatchError
+	}
+
+	for i, port := range ports {
+		if containsPort(ports[:i], port) {
+			return nil, DuplicatePortError(port)
+		}
+	}
+
+	result.ListenPorts = ports
+
+	if len(strings.TrimSpace(result.ListenIp)) < 1 {
+		result.ListenIp = "127.0.0.1"
+	}
+
+	bindAddresses, err := ParseBindAddresses(result.ListenIp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.ListenIp = strings.Join(bindAddresses, " ")
+
+	if self.Hosts < 0 {
+		return nil, IllegalHostsCountError(self.Hosts)
+	} else if self.Hosts > 1 && len(result.NodeBind) < 1 {
+		if len(bindAddresses) > 1 {
+			return nil, IllegalSimulatedHostIpError(strings.Join(bindAddresses, ","))
+		}
+
+		if result.NodeBind, err = SimulatedHostsBind(bindAddresses[0], ports, self.Hosts); err != nil {
+			return nil, err
+		}
+	}
+
+	for port, _ := range result.NodeBind {
+		if !containsPort(ports, port) {
+			return nil, NodeDoesNotExistError(port)
+		}
+	}
+
+	if len(result.Persistence.Mode) < 1 {
+		result.Persistence.Mode = PersistenceNone
+	}
+
+	if result.Persistence, err = NewPersistenceConf(
+		result.Persistence.Mode, result.Persistence.Save, result.Persistence.AppendFsync); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateRedisDirectives(result.Directives); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateRepeatedRedisDirectives(result.Repeated); err != nil {
+		return nil, err
+	}
+
+	for port, directives := range result.NodeDirectives {
+		if !containsPort(ports, port) {
+			return nil, NodeDoesNotExistError(port)
+		} else if err := ValidateRedisDirectives(directives); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(result.Password) > 0 {
+		if err := ValidatePassword(result.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, user := range result.Users {
+		if err := user.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ValidateBinaries(result.Binaries); err != nil {
+		return nil, err
+	}
+
+	distribution := DefaultSlotDistributionSpec()
+
+	if result.Distribution != nil {
+		distribution = *result.Distribution
+
+		if distribution.ReplicaCounts == nil {
+			distribution.ReplicaCounts = make(map[int]int)
+		}
+
+		if distribution.Weights == nil {
+			distribution.Weights = make(map[int]float64)
+		}
+	}
+
+	if _, err := distribution.masterPorts(ports); err != nil {
+		return nil, err
+	}
+
+	result.Distribution = &distribution
+	return &result, nil
+}
+
+// Describes the distribution of the shards the same way the distribution of the running cluster is described. Used
+// when shards come from the plan rather than from the spec
+func DistributionSpecOfShards(shards []Shard, antiAffinity bool) (SlotDistributionSpec, bool) {
+	topology := Topology{}
+
+	for _, shard := range shards {
+		masterId := shard.MasterAddress.String()
+
+		topology = append(topology, ClusterNodeInfo{
+			Id:      masterId,
+			Address: shard.MasterAddress,
+			Flags:   []string{NodeFlagMaster},
+			Slots:   shard.Slots,
+		})
+
+		for _, slaveAddress := range shard.SlavesAddresses {
+			topology = append(topology, ClusterNodeInfo{
+				Id:       slaveAddress.String(),
+				Address:  slaveAddress,
+				Flags:    []string{NodeFlagSlave},
+				MasterId: masterId,
+			})
+		}
+	}
+
+	return DistributionSpecOf(topology, antiAffinity)
+}
+
+// Describes the current distribution of the cluster. Masters keep their order and slot counts are given as weights
+// when slots are not spread evenly. Replica counts are given when replicas are not spread evenly, otherwise replicas
+// are left to be assigned round-robin. Returns false when no slots are served
+func DistributionSpecOf(topology Topology, antiAffinity bool) (SlotDistributionSpec, bool) {
+	result := SlotDistributionSpec{
+		ReplicaCounts: make(map[int]int),
+		Weights:       make(map[int]float64),
+		AntiAffinity:  antiAffinity,
+	}
+
+	masters := []ClusterNodeInfo{}
+	replicaCounts := make(map[string]int)
+
+	for _, info := range topology {
+		if info.IsMaster() && !info.IsFailed() {
+			masters = append(masters, info)
+		} else if info.IsSlave() {
+			replicaCounts[info.MasterId] += 1
+		}
+	}
+
+	sort.SliceStable(masters, func(a, b int) bool {
+		if len(masters[a].Slots) < 1 || len(masters[b].Slots) < 1 {
+			return len(masters[a].Slots) > len(masters[b].Slots)
+		}
+
+		return masters[a].Slots[0].From < masters[b].Slots[0].From
+	})
+
+	minSlots, maxSlots := RedisSlotCount, 0
+	minReplicas, maxReplicas := len(topology), 0
+
+	for _, master := range masters {
+		port := master.Address.Port
+
+		if slotCount := master.SlotCount(); slotCount < 1 {
+			result.EmptyMasters = append(result.EmptyMasters, port)
+
+			if count := replicaCounts[master.Id]; count > 0 {
+				result.ReplicaCounts[port] = count
+			}
+		} else {
+			result.Masters = append(result.Masters, port)
+			result.Weights[port] = float64(slotCount)
+			minSlots, maxSlots = min(minSlots, slotCount), max(maxSlots, slotCount)
+			minReplicas = min(minReplicas, replicaCounts[master.Id])
+			maxReplicas = max(maxReplicas, replicaCounts[master.Id])
+		}
+	}
+
+	if len(result.Masters) < 1 {
+		return result, false
+	}
+
+	if maxSlots-minSlots <= 1 {
+		result.Weights = make(map[int]float64)
+	}
+
+	if maxReplicas > minReplicas {
+		for _, master := range masters {
+			if master.SlotCount() > 0 {
+				result.ReplicaCounts[master.Address.Port] = replicaCounts[master.Id]
+			}
+		}
+	} else {
+		result.Replicas = minReplicas
+	}
+
+	return result, true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestClusterSpecConf(t *testing.T) {
+	spec := loadTestSpec(t, `
+name: test1
+nodes: 4
+start-port: 7501
+persistence:
+  mode: rdb
+directives:
+  maxmemory: 10mb
+users:
+  - name: app
+    password: secret
+distribution:
+  replicas: 1
+  anti-affinity: false
+`)
+
+	if spec.Name != "test1" {
+		t.Errorf("Expected name test1 but got %s", spec.Name)
+	}
+
+	conf, err := spec.ClusterConf()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(conf.ListenPorts, []int{7501, 7502, 7503, 7504}) {
+		t.Errorf("Unexpected ports %v", conf.ListenPorts)
+	}
+
+	if conf.ListenIp != "127.0.0.1" || !conf.Persistence.RdbEnabled() || conf.Directives["maxmemory"] != "10mb" {
+		t.Errorf("Unexpected configuration %+v", *conf)
+	}
+
+	if conf.Distribution == nil || conf.Distribution.Replicas != 1 || conf.Distribution.AntiAffinity {
+		t.Errorf("Unexpected distribution %+v", conf.Distribution)
+	}
+
+	if conf, err := loadTestSpec(t, "ports: [7501, 7502]").ClusterConf(); err != nil {
+		t.Fatal(err)
+	} else if conf.Persistence.Mode != PersistenceNone || conf.Distribution.Replicas != 1 {
+		t.Errorf("Expected defaults but got %+v", *conf)
+	}
+}
+
+func TestClusterSpecConfIllegal(t *testing.T) {
+	cases := []string{
+		"name: test1",
+		"{nodes: 3, ports: [7501, 7502]}",
+		"ports: [7501, 7501]",
+		"{nodes: 2, bind: no-such-host.invalid}",
+		"{nodes: 2, directives: {port: '6379'}}",
+		"{nodes: 2, node-directives: {9005: {maxmemory: 10mb}}}",
+		"{nodes: 2, persistence: {mode: disk}}",
+		"{nodes: 2, users: [{name: default, password: secret}]}",
+		"{nodes: 2, binaries: {kill: /bin/kill}}",
+		"{nodes: 2, binaries: {redis-server: /nonexistent/redis-server}}",
+		"{nodes: 2, distribution: {masters: [9005]}}",
+		"{nodes: 2, distribution: {replicas: 2}}",
+		"{nodes: 2, distribution: {masters: [9001], weights: {9001: .inf}}}",
+		"{nodes: 2, distribution: {masters: [9001], weights: {9001: .nan}}}",
+	}
+
+	for _, c := range cases {
+		if _, err := loadTestSpec(t, c).ClusterConf(); err == nil {
+			t.Errorf("Expected error for spec '%s'", c)
+		}
+	}
+}
+
+func TestClusterSpecRoundTrip(t *testing.T) {
+	binary, err := os.Executable()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	distribution := DefaultSlotDistributionSpec()
+	distribution.Masters = []int{7501, 7502}
+	distribution.Weights[7501] = 2
+
+	conf := &ClusterConf{
+		ListenIp:       "127.0.0.1 ::1",
+		ListenPorts:    []int{7501, 7502, 7503, 7504},
+		Persistence:    PersistenceConf{Mode: PersistenceBoth, Save: []string{"60 100"}, AppendFsync: "always"},
+		Directives:     map[string]string{"maxmemory": "10mb"},
+		NodeDirectives: map[int]map[string]string{7502: {"maxmemory": "20mb"}},
+		Password:       "secret",
+		Users:          []AclUser{AclUser{Name: "app", Password: "secret", Rules: "~app:* +@all"}},
+		Tls:            true,
+		UnixSocket:     true,
+		Binaries:       map[string]string{"redis-server": binary},
+		Distribution:   &distribution,
+	}
+
+	data, err := NewClusterSpec("test1", conf).Marshal()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := loadTestSpec(t, data)
+	loaded, err := spec.ClusterConf()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec.Name != "test1" || !reflect.DeepEqual(loaded, conf) {
+		t.Errorf("Expected %+v but got %+v", *conf, *loaded)
+	}
+}
+
+func TestDistributionSpecOf(t *testing.T) {
+	topology := Topology{
+		newTestSpecNode(newTestMaster("b", SlotRange{8192, 16383}), 7502),
+		newTestSpecNode(newTestMaster("a", SlotRange{0, 8191}), 7501),
+		newTestSpecNode(newTestMaster("c"), 7503),
+		newTestSpecNode(newTestReplica("r1", "a"), 7504),
+		newTestSpecNode(newTestReplica("r2", "b"), 7505),
+	}
+
+	spec, ok := DistributionSpecOf(topology, true)
+
+	if !ok {
+		t.Fatal("Expected distribution to be described")
+	}
+
+	if !reflect.DeepEqual(spec.Masters, []int{7501, 7502}) || !reflect.DeepEqual(spec.EmptyMasters, []int{7503}) {
+		t.Errorf("Unexpected masters %v and empty masters %v", spec.Masters, spec.EmptyMasters)
+	}
+
+	if spec.Replicas != 1 || len(spec.ReplicaCounts) > 0 || len(spec.Weights) > 0 {
+		t.Errorf("Expected even distribution but got %+v", spec)
+	}
+
+	topology[0].Slots = []SlotRange{{8192, 10000}}
+	topology[1].Slots = []SlotRange{{0, 8191}, {10001, 16383}}
+	topology[4].MasterId = "a"
+
+	spec, _ = DistributionSpecOf(topology, true)
+
+	if spec.Weights[7501] != 14575 || spec.Weights[7502] != 1809 {
+		t.Errorf("Expected slot counts as weights but got %v", spec.Weights)
+	}
+
+	if !reflect.DeepEqual(spec.ReplicaCounts, map[int]int{7501: 2, 7502: 0}) {
+		t.Errorf("Expected replica counts but got %v", spec.ReplicaCounts)
+	}
+
+	if _, ok := DistributionSpecOf(topology[2:], true); ok {
+		t.Errorf("Expected no distribution without slots")
+	}
+}
+
+func TestDistributionSpecOfShards(t *testing.T) {
+	shards, err := newTestCluster(6, 1).PrepareSlotDistribution(newTestSpec(1))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, ok := DistributionSpecOfShards(shards, false)
+
+	if !ok {
+		t.Fatal("Expected distribution to be described")
+	}
+
+	if !reflect.DeepEqual(spec.Masters, []int{9001, 9002, 9003}) || spec.Replicas != 1 || spec.AntiAffinity {
+		t.Errorf("Unexpected distribution %+v", spec)
+	}
+
+	if len(spec.Weights) > 0 || len(spec.ReplicaCounts) > 0 || len(spec.EmptyMasters) > 0 {
+		t.Errorf("Expected even distribution but got %+v", spec)
+	}
+}
+
+// Supporting code
+
+func loadTestSpec(t *testing.T, data string) ClusterSpec {
+	tmpdir, err := ioutil.TempDir("", "rcm_spec_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	fileName := path.Join(tmpdir, "cluster-spec.yml")
+
+	if err := ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := LoadClusterSpec(fileName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return spec
+}
+
+func newTestSpecNode(info ClusterNodeInfo, port int) ClusterNodeInfo {
+	info.Address = NodeAddress{Ip: "127.0.0.1", Port: port}
+	return info
+}
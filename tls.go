@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	TlsDirName            string = "tls"
+	TlsCaCertFileName     string = "ca.crt"
+	TlsCaKeyFileName      string = "ca.key"
+	TlsCertFileName       string = "redis.crt"
+	TlsKeyFileName        string = "redis.key"
+	TlsClientCertFileName string = "client.crt"
+	TlsClientKeyFileName  string = "client.key"
+
+	TlsCertValidity = 10 * 365 * 24 * time.Hour
+)
+
+var IllegalTlsCaError = errors.New("Can't load TLS certificate authority")
+
+// Pair of PEM encoded certificate and private key files
+type TlsFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+func (self TlsFiles) Enabled() bool {
+	return len(self.CertFile) > 0
+}
+
+func NewTlsFiles(dir string, certFileName string, keyFileName string) TlsFiles {
+	return TlsFiles{
+		CertFile: path.Join(dir, certFileName),
+		KeyFile:  path.Join(dir, keyFileName),
+	}
+}
+
+func ClusterTlsCa(clusterBaseDir string) TlsFiles {
+	return NewTlsFiles(path.Join(clusterBaseDir, TlsDirName), TlsCaCertFileName, TlsCaKeyFileName)
+}
+
+// Certificate used by rcm itself and exported to the applications to connect to the cluster
+func ClusterTlsClient(clusterBaseDir string) TlsFiles {
+	return NewTlsFiles(path.Join(clusterBaseDir, TlsDirName), TlsClientCertFileName, TlsClientKeyFileName)
+}
+
+// Hosts the certificates are issued for. Loopback addresses are always included
+func tlsHosts(ips ...string) []string {
+	result := []string{}
+	hosts := append(append([]string{}, ips...), "127.0.0.1", "::1", "localhost")
+
+	for _, host := range hosts {
+		if len(host) > 0 && !contains(result, host) {
+			result = append(result, host)
+		}
+	}
+
+	return result
+}
+
+// Generates self signed certificate authority which is used to sign certificates of the nodes and clients
+func GenerateTlsCa(ca TlsFiles, commonName string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return err
+	}
+
+	template, err := newCertTemplate(commonName)
+
+	if err != nil {
+		return err
+	}
+
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return err
+	}
+
+	return saveTlsFiles(ca, der, key)
+}
+
+// Generates the certificate signed by specified certificate authority. The certificate is valid for both server and
+// client authentication because nodes connect to each other using the same certificate
+func GenerateTlsCert(ca TlsFiles, files TlsFiles, commonName string, hosts []string) error {
+	caCert, caKey, err := loadTlsCa(ca)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return err
+	}
+
+	template, err := newCertTemplate(commonName)
+
+	if err != nil {
+		return err
+	}
+
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+
+	if err != nil {
+		return err
+	}
+
+	return saveTlsFiles(files, der, key)
+}
+
+func newCertTemplate(commonName string) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"RCM"},
+			CommonName:   commonName,
+		},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(TlsCertValidity),
+	}, nil
+}
+
+func loadTlsCa(ca TlsFiles) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPem, err := ioutil.ReadFile(ca.CertFile)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPem, err := ioutil.ReadFile(ca.KeyFile)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPem)
+	keyBlock, _ := pem.Decode(keyPem)
+
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, IllegalTlsCaError
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+func saveTlsFiles(files TlsFiles, der []byte, key *ecdsa.PrivateKey) error {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(files.CertFile), 0750); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(files.KeyFile), 0750); err != nil {
+		return err
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	if err := ioutil.WriteFile(files.CertFile, certPem, 0644); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(files.KeyFile, keyPem, 0600)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestGenerateTlsCert(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "rcm_tls_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+
+	ca := ClusterTlsCa(tmpdir)
+	files := NewTlsFiles(path.Join(tmpdir, "9001", TlsDirName), TlsCertFileName, TlsKeyFileName)
+
+	if err := GenerateTlsCa(ca, "test CA"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GenerateTlsCert(ca, files, "127.0.0.2:9001", tlsHosts("127.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, _, err := loadTlsCa(ca)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem, err := ioutil.ReadFile(files.CertFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(certPem)
+
+	if block == nil {
+		t.Fatal("Can't decode generated certificate")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	for _, host := range []string{"127.0.0.2", "127.0.0.1", "::1", "localhost"} {
+		_, err := cert.Verify(x509.VerifyOptions{
+			DNSName:   host,
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		})
+
+		if err != nil {
+			t.Errorf("Certificate is not valid for %s: %v", host, err)
+		}
+	}
+
+	if info, err := os.Stat(files.KeyFile); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected key file permissions %v but got %v", os.FileMode(0600), info.Mode().Perm())
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	NodeFlagMyself    = "myself"
+	NodeFlagMaster    = "master"
+	NodeFlagSlave     = "slave"
+	NodeFlagFail      = "fail"
+	NodeFlagPFail     = "fail?"
+	NodeFlagHandshake = "handshake"
+	NodeFlagNoAddr    = "noaddr"
+)
+
+func IllegalClusterNodesLineError(line string) error {
+	return fmt.Errorf("Can't parse `cluster nodes` line '%s'", line)
+}
+
+func IllegalSlotRangeError(slotRange string) error {
+	return fmt.Errorf("Illegal slot range '%s'", slotRange)
+}
+
+// Range of slots. Both bounds are inclusive the same way Redis reports them
+type SlotRange struct {
+	From int
+	To   int
+}
+
+func (self SlotRange) String() string {
+	if self.From == self.To {
+		return strconv.Itoa(self.From)
+	}
+
+	return fmt.Sprintf("%v-%v", self.From, self.To)
+}
+
+func (self SlotRange) Count() int {
+	return self.To - self.From + 1
+}
+
+// Parses slot range in form N or N-M
+func ParseSlotRange(slotRange string) (SlotRange, error) {
+	parts := strings.SplitN(strings.TrimSpace(slotRange), "-", 2)
+	bounds := make([]int, len(parts))
+
+	for i, part := range parts {
+		if n, err := strconv.Atoi(part); err != nil || n < 0 || n >= RedisSlotCount {
+			return SlotRange{}, IllegalSlotRangeError(slotRange)
+		} else {
+			bounds[i] = n
+		}
+	}
+
+	result := SlotRange{From: bounds[0], To: bounds[len(bounds)-1]}
+
+	if result.From > result.To {
+		return SlotRange{}, IllegalSlotRangeError(slotRange)
+	}
+
+	return result, nil
+}
+
+// Parses comma separated slot ranges in form N or N-M
+func ParseSlotRanges(slotRanges string) ([]SlotRange, error) {
+	parts := strings.Split(slotRanges, ",")
+	result := make([]SlotRange, len(parts))
+
+	for i, part := range parts {
+		if slotRange, err := ParseSlotRange(part); err != nil {
+			return nil, err
+		} else {
+			result[i] = slotRange
+		}
+	}
+
+	return result, nil
+}
+
+// Expands ranges to the sorted list of distinct slots
+func SlotsOf(ranges []SlotRange) []int {
+	seen := make(map[int]bool)
+	result := []int{}
+
+	for _, r := range ranges {
+		for slot := r.From; slot <= r.To; slot++ {
+			if !seen[slot] {
+				seen[slot] = true
+				result = append(result, slot)
+			}
+		}
+	}
+
+	sort.Ints(result)
+	return result
+}
+
+// Compresses slots to the minimal list of ranges
+func SlotRangesOf(slots []int) []SlotRange {
+	sorted := append([]int{}, slots...)
+	sort.Ints(sorted)
+
+	result := []SlotRange{}
+
+	for _, slot := range sorted {
+		if last := len(result) - 1; last >= 0 && result[last].To+1 == slot {
+			result[last].To = slot
+		} else if last < 0 || result[last].To != slot {
+			result = append(result, SlotRange{From: slot, To: slot})
+		}
+	}
+
+	return result
+}
+
+func FormatSlotRanges(ranges []SlotRange) string {
+	result := make([]string, len(ranges))
+
+	for i, r := range ranges {
+		result[i] = r.String()
+	}
+
+	return strings.Join(result, ",")
+}
+
+// Single line of `cluster nodes` output
+type ClusterNodeInfo struct {
+	Id        string
+	Address   NodeAddress
+	Flags     []string
+	MasterId  string
+	LinkState string
+	Slots     []SlotRange
+	Migrating map[int]string
+	Importing map[int]string
+}
+
+func (self ClusterNodeInfo) HasFlag(flag string) bool {
+	return contains(self.Flags, flag)
+}
+
+func (self ClusterNodeInfo) IsMyself() bool {
+	return self.HasFlag(NodeFlagMyself)
+}
+
+func (self ClusterNodeInfo) IsMaster() bool {
+	return self.HasFlag(NodeFlagMaster)
+}
+
+func (self ClusterNodeInfo) IsSlave() bool {
+	return self.HasFlag(NodeFlagSlave)
+}
+
+func (self ClusterNodeInfo) IsFailed() bool {
+	return self.HasFlag(NodeFlagFail) || self.HasFlag(NodeFlagNoAddr)
+}
+
+func (self ClusterNodeInfo) SlotCount() int {
+	result := 0
+
+	for _, r := range self.Slots {
+		result += r.Count()
+	}
+
+	return result
+}
+
+// Parses the output of `cluster nodes` command. IPv6 addresses are reported by Redis without brackets so the port
+// is separated by the last colon
+func ParseClusterNodes(output string) ([]ClusterNodeInfo, error) {
+	result := []ClusterNodeInfo{}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		if len(line) < 1 {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) < 8 {
+			return nil, IllegalClusterNodesLineError(line)
+		}
+
+		address, err := parseClusterNodeAddress(fields[1])
+
+		if err != nil {
+			return nil, IllegalClusterNodesLineError(line)
+		}
+
+		info := ClusterNodeInfo{
+			Id:        fields[0],
+			Address:   address,
+			Flags:     strings.Split(fields[2], ","),
+			LinkState: fields[7],
+			Migrating: make(map[int]string),
+			Importing: make(map[int]string),
+		}
+
+		if fields[3] != "-" {
+			info.MasterId = fields[3]
+		}
+
+		for _, field := range fields[8:] {
+			if strings.HasPrefix(field, "[") {
+				if err := info.parseOpenSlot(strings.Trim(field, "[]")); err != nil {
+					return nil, IllegalClusterNodesLineError(line)
+				}
+			} else if r, err := ParseSlotRange(field); err != nil {
+				return nil, IllegalClusterNodesLineError(line)
+			} else {
+				info.Slots = append(info.Slots, r)
+			}
+		}
+
+		result = append(result, info)
+	}
+
+	return result, nil
+}
+
+// Parses slots in migrating ([slot->-id]) or importing ([slot-<-id]) state
+func (self *ClusterNodeInfo) parseOpenSlot(field string) error {
+	var target map[int]string
+	var parts []string
+
+	if parts = strings.SplitN(field, "->-", 2); len(parts) == 2 {
+		target = self.Migrating
+	} else if parts = strings.SplitN(field, "-<-", 2); len(parts) == 2 {
+		target = self.Importing
+	} else {
+		return IllegalSlotRangeError(field)
+	}
+
+	slot, err := strconv.Atoi(parts[0])
+
+	if err != nil {
+		return err
+	}
+
+	target[slot] = parts[1]
+	return nil
+}
+
+// Parses address in form ip:port@cport[,hostname]. Redis prior to 4.0 omits the cluster bus port
+func parseClusterNodeAddress(address string) (NodeAddress, error) {
+	if i := strings.Index(address, ","); i >= 0 {
+		address = address[:i]
+	}
+
+	if i := strings.Index(address, "@"); i >= 0 {
+		address = address[:i]
+	}
+
+	i := strings.LastIndex(address, ":")
+
+	if i < 0 {
+		return NodeAddress{}, fmt.Errorf("Illegal node address '%s'", address)
+	}
+
+	port, err := strconv.Atoi(address[i+1:])
+
+	if err != nil {
+		return NodeAddress{}, err
+	}
+
+	return NewNodeAddress(strings.Trim(address[:i], "[]"), port), nil
+}
+
+// Rewrites ports in the content of nodes.conf. Cluster bus ports are moved along with the ports of the nodes
+func RewriteNodesConf(content string, portMap map[int]int) string {
+	lines := strings.Split(content, "\n")
+
+	for i, line := range lines {
+		fields := strings.Fields(line)
+
+		if len(fields) < 8 {
+			continue
+		}
+
+		fields[1] = rewriteClusterNodeAddress(fields[1], portMap)
+		lines[i] = strings.Join(fields, " ")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Rewrites ports of the address in form ip:port@cport[,hostname]
+func rewriteClusterNodeAddress(address string, portMap map[int]int) string {
+	hostname := ""
+
+	if i := strings.Index(address, ","); i >= 0 {
+		address, hostname = address[:i], address[i:]
+	}
+
+	busPort := ""
+
+	if i := strings.Index(address, "@"); i >= 0 {
+		address, busPort = address[:i], address[i:]
+	}
+
+	if i := strings.LastIndex(address, ":"); i >= 0 {
+		if port, err := strconv.Atoi(address[i+1:]); err == nil {
+			if newPort, ok := portMap[port]; ok {
+				address = address[:i+1] + strconv.Itoa(newPort)
+
+				if busPort == fmt.Sprintf("@%v", port+RedisGossipPortIncrement) {
+					busPort = fmt.Sprintf("@%v", newPort+RedisGossipPortIncrement)
+				}
+			}
+		}
+	}
+
+	return address + busPort + hostname
+}
+
+// Snapshot of the cluster state as it seen by particular node
+type Topology []ClusterNodeInfo
+
+func (self Topology) ById(id string) (ClusterNodeInfo, bool) {
+	for _, info := range self {
+		if info.Id == id {
+			return info, true
+		}
+	}
+
+	return ClusterNodeInfo{}, false
+}
+
+func (self Topology) ByPort(port int) (ClusterNodeInfo, bool) {
+	for _, info := range self {
+		if info.Address.Port == port {
+			return info, true
+		}
+	}
+
+	return ClusterNodeInfo{}, false
+}
+
+func (self Topology) Myself() (ClusterNodeInfo, bool) {
+	for _, info := range self {
+		if info.IsMyself() {
+			return info, true
+		}
+	}
+
+	return ClusterNodeInfo{}, false
+}
+
+// Returns masters which are not failed
+func (self Topology) Masters() []ClusterNodeInfo {
+	result := []ClusterNodeInfo{}
+
+	for _, info := range self {
+		if info.IsMaster() && !info.IsFailed() {
+			result = append(result, info)
+		}
+	}
+
+	return result
+}
+
+func (self Topology) SlavesOf(masterId string) []ClusterNodeInfo {
+	result := []ClusterNodeInfo{}
+
+	for _, info := range self {
+		if info.IsSlave() && info.MasterId == masterId {
+			result = append(result, info)
+		}
+	}
+
+	return result
+}
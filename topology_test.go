@@ -0,0 +1,168 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testClusterNodesOutput = `07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:9004@19004 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected
+67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 127.0.0.1:9002@19002 master - 0 1426238316232 2 connected 5461-10922
+292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 127.0.0.1:9003@19003 master - 0 1426238318243 3 connected 10923-16383 [10922-<-67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1]
+6ec23923021cf3ffec47632106199cb7f496ce01 127.0.0.1:9005@19005 slave 67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 0 1426238316232 5 connected
+824fe116063bc5fcf9f4ffd895bc17aee7731ac3 ::1:9006@19006,node6 slave 292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 0 1426238317741 6 connected
+e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:9001@19001 myself,master - 0 0 1 connected 0-5460 5462 [5461->-292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f]
+a1b2c3d4e5f60718293a4b5c6d7e8f9012345678 :0@0 master,fail,noaddr - 1426238300000 1426238299000 7 disconnected
+`
+
+func TestParseClusterNodes(t *testing.T) {
+	topology, err := ParseClusterNodes(testClusterNodesOutput)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(topology) != 7 {
+		t.Fatalf("Expected %v nodes but got %v", 7, len(topology))
+	}
+
+	myself, ok := Topology(topology).Myself()
+
+	if !ok {
+		t.Fatal("Expected myself record to be present")
+	}
+
+	if myself.Address != NewNodeAddress("127.0.0.1", 9001) || !myself.IsMaster() {
+		t.Errorf("Unexpected myself record %v", myself)
+	}
+
+	if expected := []SlotRange{SlotRange{0, 5460}, SlotRange{5462, 5462}}; !reflect.DeepEqual(myself.Slots, expected) {
+		t.Errorf("Expected %v but got %v", expected, myself.Slots)
+	}
+
+	if myself.SlotCount() != 5462 {
+		t.Errorf("Expected %v slots but got %v", 5462, myself.SlotCount())
+	}
+
+	if id := myself.Migrating[5461]; id != "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f" {
+		t.Errorf("Expected slot 5461 to be migrating but got %v", myself.Migrating)
+	}
+
+	importing, _ := Topology(topology).ByPort(9003)
+
+	if id := importing.Importing[10922]; id != "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1" {
+		t.Errorf("Expected slot 10922 to be importing but got %v", importing.Importing)
+	}
+
+	ipv6, _ := Topology(topology).ByPort(9006)
+
+	if ipv6.Address != NewNodeAddress("::1", 9006) || ipv6.MasterId != "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f" {
+		t.Errorf("Unexpected IPv6 record %v", ipv6)
+	}
+
+	if masters := Topology(topology).Masters(); len(masters) != 3 {
+		t.Errorf("Expected %v live masters but got %v", 3, len(masters))
+	}
+
+	if slaves := Topology(topology).SlavesOf("67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1"); len(slaves) != 1 || slaves[0].Address.Port != 9005 {
+		t.Errorf("Unexpected slaves %v", slaves)
+	}
+
+	failed, _ := Topology(topology).ById("a1b2c3d4e5f60718293a4b5c6d7e8f9012345678")
+
+	if !failed.IsFailed() {
+		t.Errorf("Expected node to be failed")
+	}
+}
+
+func TestParseClusterNodesRejectsGarbage(t *testing.T) {
+	if _, err := ParseClusterNodes("ERR This instance has cluster support disabled"); err == nil {
+		t.Errorf("Expected error")
+	}
+}
+
+func TestSlotRanges(t *testing.T) {
+	slots := []int{5, 1, 2, 3, 3, 7, 8, 16383}
+	expected := []SlotRange{SlotRange{1, 3}, SlotRange{5, 5}, SlotRange{7, 8}, SlotRange{16383, 16383}}
+
+	ranges := SlotRangesOf(slots)
+
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Expected %v but got %v", expected, ranges)
+	}
+
+	if s := FormatSlotRanges(ranges); s != "1-3,5,7-8,16383" {
+		t.Errorf("Unexpected format %v", s)
+	}
+
+	if expandedSlots := SlotsOf(ranges); !reflect.DeepEqual(expandedSlots, []int{1, 2, 3, 5, 7, 8, 16383}) {
+		t.Errorf("Unexpected slots %v", expandedSlots)
+	}
+
+	for _, illegal := range []string{"", "a", "5-1", "0-16384", "-1"} {
+		if _, err := ParseSlotRange(illegal); err == nil {
+			t.Errorf("Expected '%s' to be rejected", illegal)
+		}
+	}
+}
+
+func TestRewriteNodesConf(t *testing.T) {
+	content := "a1 127.0.0.1:9001@19001 myself,master - 0 0 1 connected 0-8191\n" +
+		"b2 ::1:9002@19002,host-b master - 0 1700000000000 2 connected 8192-16383\n" +
+		"c3 127.0.0.1:9003@29003 slave a1 0 1700000000000 1 connected\n" +
+		"vars currentEpoch 2 lastVoteEpoch 0\n"
+
+	expected := "a1 127.0.0.1:9101@19101 myself,master - 0 0 1 connected 0-8191\n" +
+		"b2 ::1:9102@19102,host-b master - 0 1700000000000 2 connected 8192-16383\n" +
+		"c3 127.0.0.1:9103@29003 slave a1 0 1700000000000 1 connected\n" +
+		"vars currentEpoch 2 lastVoteEpoch 0\n"
+
+	result := RewriteNodesConf(content, map[int]int{9001: 9101, 9002: 9102, 9003: 9103})
+
+	if result != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, result)
+	}
+}
+
+func TestOpenSlotsOfInterruptedMigration(t *testing.T) {
+	const sourceId = "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca"
+	const targetId = "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f"
+	const otherId = "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1"
+
+	// The source was interrupted before it learned that slot 5460 is assigned to the target. Slot 5462 was marked
+	// importing on the target only. Slot 100 is migrating to another node
+	sourceView := sourceId + " 127.0.0.1:9001@19001 myself,master - 0 0 1 connected 0-5462 " +
+		"[5460->-" + targetId + "] [5461->-" + targetId + "] [100->-" + otherId + "]\n"
+	targetView := targetId + " 127.0.0.1:9003@19003 myself,master - 0 0 3 connected 5460 10923-16383 " +
+		"[5462-<-" + sourceId + "] [10922-<-" + otherId + "]\n"
+
+	sourceTopology, err := ParseClusterNodes(sourceView)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetTopology, err := ParseClusterNodes(targetView)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, _ := Topology(sourceTopology).Myself()
+	target, _ := Topology(targetTopology).Myself()
+
+	open := openSlotsBetween(sourceId, targetId, source, target)
+
+	if !reflect.DeepEqual(open, []int{5460, 5461, 5462}) {
+		t.Fatalf("Expected slots 5460-5462 to be open but got %v", open)
+	}
+
+	expected := []SlotResume{{Slot: 5460, KeysOnly: true}, {Slot: 5461}, {Slot: 5462}}
+
+	if resumes := resumesOf(target, open); !reflect.DeepEqual(resumes, expected) {
+		t.Errorf("Expected %v but got %v", expected, resumes)
+	}
+
+	if open := openSlotsBetween(targetId, sourceId, target, source); len(open) > 0 {
+		t.Errorf("Expected no slots open in the opposite direction but got %v", open)
+	}
+}
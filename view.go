@@ -8,4 +8,7 @@ type View interface {
 	Echo(format string, args ...interface{})
 
 	Success(format string, args ...interface{})
+
+	// Reports progress of long running operation. The line is updated in place until current reaches total
+	Progress(current int, total int, format string, args ...interface{})
 }